@@ -0,0 +1,172 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// ActivityBar shows a spinner and a cancel button while actions started
+// from the window are in flight. Actions run in the background, so a slow
+// speaker or resolver no longer freezes the window and can be given up on.
+type ActivityBar struct {
+	mu       sync.Mutex
+	running  map[int]activity
+	nextId   int
+	latestId int
+
+	label    *widget.Label
+	progress *widget.ProgressBarInfinite
+	box      *fyne.Container
+}
+
+type activity struct {
+	message string
+	cancel  context.CancelFunc
+}
+
+func newActivityBar() *ActivityBar {
+	b := &ActivityBar{running: make(map[int]activity)}
+
+	b.label = widget.NewLabel("")
+	b.progress = widget.NewProgressBarInfinite()
+	b.progress.Stop()
+	cancelButton := widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), b.Cancel)
+
+	b.box = container.NewBorder(nil, nil, nil, cancelButton, container.NewVBox(b.label, b.progress))
+	b.box.Hide()
+	return b
+}
+
+func (b *ActivityBar) Object() fyne.CanvasObject {
+	return b.box
+}
+
+// Run runs fn in the background while showing message. The context passed
+// to fn is cancelled by the cancel button. Errors are shown in w, except
+// for the cancellation itself.
+func (b *ActivityBar) Run(w fyne.Window, message string, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b.mu.Lock()
+	id := b.nextId
+	b.nextId++
+	b.latestId = id
+	b.running[id] = activity{message: message, cancel: cancel}
+	b.mu.Unlock()
+	b.update()
+
+	go func() {
+		err := fn(ctx)
+		cancel()
+
+		b.mu.Lock()
+		delete(b.running, id)
+		b.mu.Unlock()
+		b.update()
+
+		showUnlessCancelled(err, w)
+	}()
+}
+
+// Cancel cancels every action in flight.
+func (b *ActivityBar) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, running := range b.running {
+		running.cancel()
+	}
+}
+
+func (b *ActivityBar) update() {
+	b.mu.Lock()
+	count := len(b.running)
+	message := ""
+	if latest, ok := b.running[b.latestId]; ok {
+		message = latest.message
+	}
+	for _, running := range b.running {
+		if message == "" {
+			message = running.message
+		}
+	}
+	b.mu.Unlock()
+
+	if count == 0 {
+		b.progress.Stop()
+		b.box.Hide()
+		return
+	}
+
+	if count > 1 {
+		message = fmt.Sprintf("%s (and %d more)", message, count-1)
+	}
+	b.label.SetText(message)
+	b.box.Show()
+	b.progress.Start()
+}
+
+// SliderSender sends the values a slider is dragged over to the speaker
+// through an ActivityBar. Every step starts a change, but the ones overtaken
+// by a later step before their turn are dropped, so the speaker gets the
+// latest value instead of a backlog of stale ones in any order.
+type SliderSender struct {
+	bar     *ActivityBar
+	w       fyne.Window
+	message string
+	send    func(ctx context.Context, value int) error
+
+	// mu is held while sending, steps count the values asked for
+	mu    sync.Mutex
+	steps atomic.Uint64
+}
+
+func newSliderSender(bar *ActivityBar, w fyne.Window, message string, send func(ctx context.Context, value int) error) *SliderSender {
+	return &SliderSender{bar: bar, w: w, message: message, send: send}
+}
+
+// Send sends value once the changes before it are done, unless another
+// value is asked for first.
+func (s *SliderSender) Send(value int) {
+	step := s.steps.Add(1)
+	s.bar.Run(s.w, s.message, func(ctx context.Context) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.steps.Load() != step {
+			return nil
+		}
+		return s.send(ctx, value)
+	})
+}
+
+// showUnlessCancelled shows err in w, unless it is only the user cancelling.
+func showUnlessCancelled(err error, w fyne.Window) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	dialog.ShowError(err, w)
+}
@@ -0,0 +1,525 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ControlApi is the JSON API other programs control playback with. It is
+// served by the redirector under /api/v1 and disabled while the token is
+// empty.
+type ControlApi struct {
+	Controller *Controller
+
+	// SelectDevice switches to another speaker.
+	SelectDevice func(device Device) error
+
+	// The token is changed in the settings while requests come in
+	mu    sync.Mutex
+	token string
+}
+
+var controlApi = &ControlApi{Controller: controller}
+
+// Token returns the token requests have to carry.
+func (api *ControlApi) Token() string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.token
+}
+
+// SetToken changes the token, an empty token disables the API.
+func (api *ControlApi) SetToken(token string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.token = token
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiDevice struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	UDN      string `json:"udn"`
+	Selected bool   `json:"selected"`
+}
+
+type apiTrack struct {
+	Id            string `json:"id"`
+	Title         string `json:"title"`
+	LengthSeconds int    `json:"length_seconds"`
+}
+
+type apiQueue struct {
+	Current int        `json:"current"`
+	Items   []apiTrack `json:"items"`
+}
+
+type apiStatus struct {
+	Device   string    `json:"device"`
+	State    string    `json:"state"`
+	Track    *apiTrack `json:"track"`
+	Position int       `json:"position"`
+	Duration int       `json:"duration"`
+	Volume   int       `json:"volume"`
+}
+
+type apiUrlRequest struct {
+	Url string `json:"url"`
+}
+
+type apiVolume struct {
+	Volume int `json:"volume"`
+}
+
+type apiCurrentTrack struct {
+	Track *apiTrack `json:"track"`
+}
+
+type apiTransportState struct {
+	State    string `json:"state"`
+	Position int    `json:"position"`
+	Duration int    `json:"duration"`
+}
+
+type apiSeek struct {
+	Position int `json:"position"`
+}
+
+type apiSelectDevice struct {
+	Name string `json:"name"`
+}
+
+func newApiTrack(track Track) apiTrack {
+	return apiTrack{Id: track.YtId, Title: track.Title, LengthSeconds: track.LengthSeconds}
+}
+
+func newApiDevices(devices []Device, selected Device) []apiDevice {
+	list := []apiDevice{}
+	for _, device := range devices {
+		list = append(list, apiDevice{
+			Name:     device.Name,
+			Host:     device.Host,
+			UDN:      device.UDN,
+			Selected: device.UDN == selected.UDN,
+		})
+	}
+	return list
+}
+
+func newApiQueue(current int, items []Track) apiQueue {
+	response := apiQueue{Current: current, Items: []apiTrack{}}
+	for _, track := range items {
+		response.Items = append(response.Items, newApiTrack(track))
+	}
+	return response
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, apiError{Error: err.Error()})
+}
+
+// Routes returns the handler for everything below /api/v1.
+func (api *ControlApi) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(api.authenticate)
+
+	r.Get("/devices", api.devices)
+	r.Put("/device", api.selectDevice)
+	r.Get("/events", api.events)
+	r.Get("/status", api.status)
+	r.Post("/play", api.play)
+	r.Post("/pause", api.transport(api.Controller.Pause))
+	r.Post("/stop", api.transport(api.Controller.Stop))
+	r.Post("/seek", api.seek)
+	r.Post("/next", api.skip(api.Controller.Queue().Next))
+	r.Post("/previous", api.skip(api.Controller.Queue().Previous))
+	r.Get("/queue", api.getQueue)
+	r.Post("/queue", api.addToQueue)
+	r.Delete("/queue", api.transport(api.Controller.Queue().Clear))
+	r.Delete("/queue/{index}", api.removeFromQueue)
+	r.Get("/volume", api.getVolume)
+	r.Put("/volume", api.setVolume)
+	return r
+}
+
+// authenticate requires the token as a bearer token. EventSource can't set
+// headers, so it may be passed as the token query parameter as well.
+func (api *ControlApi) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := api.Token()
+		if expected == "" {
+			writeApiError(w, http.StatusForbidden, errors.New("the API is disabled, set a token in the settings"))
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeApiError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireDevice writes an error and returns false when no device is selected.
+func (api *ControlApi) requireDevice(w http.ResponseWriter) bool {
+	if !api.Controller.HasDevice() {
+		writeApiError(w, http.StatusConflict, errNoDevice)
+		return false
+	}
+	return true
+}
+
+func (api *ControlApi) devices(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, newApiDevices(discovery.Devices(), api.Controller.Device()))
+}
+
+// eventPayload converts an event to what the events stream sends for it.
+func eventPayload(event Event) interface{} {
+	switch event := event.(type) {
+	case TrackChanged:
+		if event.Track == nil {
+			return apiCurrentTrack{}
+		}
+		track := newApiTrack(*event.Track)
+		return apiCurrentTrack{Track: &track}
+	case TransportStateChanged:
+		return apiTransportState{State: event.Status.State, Position: event.Status.Position, Duration: event.Status.Duration}
+	case VolumeChanged:
+		return apiVolume{Volume: event.Volume}
+	case DeviceListChanged:
+		return newApiDevices(event.Devices, event.Selected)
+	case QueueChanged:
+		return newApiQueue(event.Current, event.Items)
+	}
+	return event
+}
+
+// events streams the event bus with Server-Sent Events. It starts with the
+// latest event of every kind, so clients don't need to ask for the state
+// separately.
+func (api *ControlApi) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeApiError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	// Slow clients lose events rather than holding up the publisher
+	stream := make(chan Event, 32)
+	stopWatching := api.Controller.Events().Watch(func(event Event) {
+		select {
+		case stream <- event:
+		default:
+		}
+	})
+	defer stopWatching()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event Event) {
+		data, err := json.Marshal(eventPayload(event))
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.EventName(), data)
+		flusher.Flush()
+	}
+
+	for _, event := range api.Controller.Events().Latest() {
+		send(event)
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-stream:
+			send(event)
+		}
+	}
+}
+
+func (api *ControlApi) selectDevice(w http.ResponseWriter, r *http.Request) {
+	request := apiSelectDevice{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	device, ok := discovery.Find(request.Name)
+	if !ok {
+		writeApiError(w, http.StatusNotFound, errors.New("could not find device"))
+		return
+	}
+
+	if api.SelectDevice == nil {
+		writeApiError(w, http.StatusNotImplemented, errors.New("switching devices is not supported"))
+		return
+	}
+
+	err = api.SelectDevice(device)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ControlApi) seek(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	request := apiSeek{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Position < 0 {
+		writeApiError(w, http.StatusBadRequest, errors.New("position can't be negative"))
+		return
+	}
+
+	err = api.Controller.Seek(r.Context(), request.Position)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ControlApi) status(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	status, err := api.Controller.Poll(r.Context())
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	volume, err := api.Controller.Volume(r.Context())
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	response := apiStatus{
+		Device:   api.Controller.Device().Name,
+		State:    status.State,
+		Position: status.Position,
+		Duration: status.Duration,
+		Volume:   volume,
+	}
+	if track, ok := api.Controller.Queue().CurrentTrack(); ok {
+		current := newApiTrack(track)
+		response.Track = &current
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func decodeUrlRequest(r *http.Request) (string, error) {
+	request := apiUrlRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return "", err
+	}
+	if request.Url == "" {
+		return "", errors.New("url is required")
+	}
+	return request.Url, nil
+}
+
+// play starts the video in the request body, or resumes playback when the
+// body is empty.
+func (api *ControlApi) play(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	if r.ContentLength == 0 {
+		err := api.Controller.Play(r.Context())
+		if err != nil {
+			writeApiError(w, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	url, err := decodeUrlRequest(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	track, err := api.Controller.PlayUrl(r.Context(), url)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, newApiTrack(track))
+}
+
+// transport wraps an action without arguments or response.
+func (api *ControlApi) transport(action func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !api.requireDevice(w) {
+			return
+		}
+
+		err := action(r.Context())
+		if err != nil {
+			writeApiError(w, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// skip wraps a queue action that changes the current track. Running out of
+// tracks is a conflict with the state of the queue, anything else the
+// speaker failing.
+func (api *ControlApi) skip(action func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !api.requireDevice(w) {
+			return
+		}
+
+		err := action(r.Context())
+		if errors.Is(err, errNoNextTrack) || errors.Is(err, errNoPreviousTrack) || errors.Is(err, errQueueReset) {
+			writeApiError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeApiError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		track, _ := api.Controller.Queue().CurrentTrack()
+		writeJson(w, http.StatusOK, newApiTrack(track))
+	}
+}
+
+func (api *ControlApi) getQueue(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, newApiQueue(api.Controller.Queue().Current(), api.Controller.Queue().Items()))
+}
+
+func (api *ControlApi) addToQueue(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	url, err := decodeUrlRequest(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	track, err := api.Controller.Enqueue(r.Context(), url)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, newApiTrack(track))
+}
+
+func (api *ControlApi) removeFromQueue(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || index < 0 || index >= len(api.Controller.Queue().Items()) {
+		writeApiError(w, http.StatusNotFound, errors.New("queue index out of range"))
+		return
+	}
+
+	err = api.Controller.Queue().Remove(r.Context(), index)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *ControlApi) getVolume(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	volume, err := api.Controller.Volume(r.Context())
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJson(w, http.StatusOK, apiVolume{Volume: volume})
+}
+
+func (api *ControlApi) setVolume(w http.ResponseWriter, r *http.Request) {
+	if !api.requireDevice(w) {
+		return
+	}
+
+	request := apiVolume{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Volume < 0 || request.Volume > 100 {
+		writeApiError(w, http.StatusBadRequest, errors.New("volume has to be between 0 and 100"))
+		return
+	}
+
+	err = api.Controller.SetVolume(r.Context(), request.Volume)
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJson(w, http.StatusOK, request)
+}
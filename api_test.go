@@ -0,0 +1,273 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testApiToken = "secret"
+
+// newTestApi serves the API for c with testApiToken until the test ends.
+func newTestApi(t *testing.T, c *Controller) *httptest.Server {
+	api := &ControlApi{Controller: c}
+	api.SetToken(testApiToken)
+
+	server := httptest.NewServer(api.Routes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// apiCall sends body to path with the test token and returns the response
+// status and body.
+func apiCall(t *testing.T, server *httptest.Server, method string, path string, body string) (int, string) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testApiToken)
+
+	return doApiRequest(t, req)
+}
+
+func doApiRequest(t *testing.T, req *http.Request) (int, string) {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(bodyBytes)
+}
+
+func expectStatus(t *testing.T, what string, got int, body string, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("%s: expected %d, got %d: %s", what, want, got, strings.TrimSpace(body))
+	}
+}
+
+func TestApiAuthentication(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Office")
+	api := &ControlApi{Controller: newTestController(speaker)}
+	server := httptest.NewServer(api.Routes())
+	defer server.Close()
+
+	get := func(path string, authorization string) int {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		status, _ := doApiRequest(t, req)
+		return status
+	}
+
+	// Without a token the API is off, whatever is sent
+	if status := get("/queue", "Bearer "); status != http.StatusForbidden {
+		t.Errorf("expected the disabled API to answer 403, got %d", status)
+	}
+
+	api.SetToken(testApiToken)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"no token", "/queue", "", http.StatusUnauthorized},
+		{"wrong token", "/queue", "Bearer wrong", http.StatusUnauthorized},
+		{"token prefix", "/queue", "Bearer " + testApiToken[:3], http.StatusUnauthorized},
+		{"bearer token", "/queue", "Bearer " + testApiToken, http.StatusOK},
+		// How the web remote opens the event stream
+		{"query token", "/queue?token=" + testApiToken, "", http.StatusOK},
+		{"wrong query token", "/queue?token=wrong", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		if status := get(test.path, test.authorization); status != test.want {
+			t.Errorf("%s: expected %d, got %d", test.name, test.want, status)
+		}
+	}
+}
+
+func TestApiWithoutDevice(t *testing.T) {
+	server := newTestApi(t, newController(&ZoneGroupTopology{}, &EventBus{}))
+
+	status, body := apiCall(t, server, http.MethodGet, "/status", "")
+	expectStatus(t, "status", status, body, http.StatusConflict)
+
+	status, body = apiCall(t, server, http.MethodPost, "/play", "")
+	expectStatus(t, "play", status, body, http.StatusConflict)
+}
+
+func TestApiPlay(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Kitchen")
+	server := newTestApi(t, newTestController(speaker))
+	useInvidious(t, newFakeInvidious(t), 0, "")
+
+	// An empty body resumes, and there is nothing to resume yet
+	status, body := apiCall(t, server, http.MethodPost, "/play", "")
+	expectStatus(t, "resume without media", status, body, http.StatusBadGateway)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"invalid JSON", `{"url":`, http.StatusBadRequest},
+		{"missing url", `{}`, http.StatusBadRequest},
+		{"not YouTube", `{"url": "https://vimeo.com/76979871"}`, http.StatusBadGateway},
+		{"url", `{"url": "https://youtu.be/dQw4w9WgXcQ"}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		status, body := apiCall(t, server, http.MethodPost, "/play", test.body)
+		expectStatus(t, test.name, status, body, test.want)
+	}
+
+	state, _, _ := speaker.State()
+	if state != transportPlaying {
+		t.Fatalf("expected the video to play, got %s", state)
+	}
+
+	status, body = apiCall(t, server, http.MethodPost, "/pause", "")
+	expectStatus(t, "pause", status, body, http.StatusNoContent)
+
+	status, body = apiCall(t, server, http.MethodPost, "/play", "")
+	expectStatus(t, "resume", status, body, http.StatusNoContent)
+	if state, _, _ := speaker.State(); state != transportPlaying {
+		t.Fatalf("expected playback to resume, got %s", state)
+	}
+
+	status, body = apiCall(t, server, http.MethodGet, "/status", "")
+	expectStatus(t, "status", status, body, http.StatusOK)
+	response := apiStatus{}
+	err := json.Unmarshal([]byte(body), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.Device, "Kitchen") || response.Track == nil || response.Track.Id != "dQw4w9WgXcQ" {
+		t.Errorf("expected the video playing on Kitchen, got %s", body)
+	}
+}
+
+func TestApiVolume(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Bedroom")
+	server := newTestApi(t, newTestController(speaker))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"too low", `{"volume": -1}`, http.StatusBadRequest},
+		{"too high", `{"volume": 101}`, http.StatusBadRequest},
+		{"invalid JSON", `{"volume": "loud"}`, http.StatusBadRequest},
+		{"lowest", `{"volume": 0}`, http.StatusOK},
+		{"highest", `{"volume": 100}`, http.StatusOK},
+		{"volume", `{"volume": 30}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		status, body := apiCall(t, server, http.MethodPut, "/volume", test.body)
+		expectStatus(t, test.name, status, body, test.want)
+	}
+
+	status, body := apiCall(t, server, http.MethodGet, "/volume", "")
+	expectStatus(t, "get volume", status, body, http.StatusOK)
+	if strings.TrimSpace(body) != `{"volume":30}` {
+		t.Errorf("expected the volume last set, got %s", body)
+	}
+}
+
+func TestApiQueue(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Garden")
+	server := newTestApi(t, newTestController(speaker))
+	useInvidious(t, newFakeInvidious(t), 0, "")
+
+	// Nothing to skip to yet is the state of the queue, not the speaker failing
+	status, body := apiCall(t, server, http.MethodPost, "/next", "")
+	expectStatus(t, "next on an empty queue", status, body, http.StatusConflict)
+	status, body = apiCall(t, server, http.MethodPost, "/previous", "")
+	expectStatus(t, "previous on an empty queue", status, body, http.StatusConflict)
+
+	for _, id := range []string{"dQw4w9WgXcQ", "jNQXAC9IVRw"} {
+		status, body := apiCall(t, server, http.MethodPost, "/queue", `{"url": "https://youtu.be/`+id+`"}`)
+		expectStatus(t, "add "+id, status, body, http.StatusCreated)
+	}
+
+	tests := []struct {
+		index string
+		want  int
+	}{
+		{"-1", http.StatusNotFound},
+		{"2", http.StatusNotFound},
+		{"first", http.StatusNotFound},
+		{"1", http.StatusNoContent},
+		// Only one is left
+		{"1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		status, body := apiCall(t, server, http.MethodDelete, "/queue/"+test.index, "")
+		expectStatus(t, "remove "+test.index, status, body, test.want)
+	}
+
+	status, body = apiCall(t, server, http.MethodGet, "/queue", "")
+	expectStatus(t, "queue", status, body, http.StatusOK)
+	queue := apiQueue{}
+	err := json.Unmarshal([]byte(body), &queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Items) != 1 || queue.Items[0].Id != "dQw4w9WgXcQ" {
+		t.Errorf("expected only the first video to be left, got %s", body)
+	}
+}
+
+func TestApiSkipSpeakerFailure(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Hallway")
+	server := newTestApi(t, newTestController(speaker))
+	useInvidious(t, newFakeInvidious(t), 0, "")
+
+	status, body := apiCall(t, server, http.MethodPost, "/queue", `{"url": "https://youtu.be/dQw4w9WgXcQ"}`)
+	expectStatus(t, "add", status, body, http.StatusCreated)
+	status, body = apiCall(t, server, http.MethodPost, "/play", `{"url": "https://youtu.be/jNQXAC9IVRw"}`)
+	expectStatus(t, "play", status, body, http.StatusOK)
+
+	// There is a previous track, so this is the speaker failing
+	speaker.server.Close()
+	status, body = apiCall(t, server, http.MethodPost, "/previous", "")
+	expectStatus(t, "previous with the speaker gone", status, body, http.StatusBadGateway)
+}
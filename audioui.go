@@ -0,0 +1,133 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// AudioSettings are the tone settings of a single speaker.
+type AudioSettings struct {
+	Muted    bool
+	Bass     int
+	Treble   int
+	Loudness bool
+}
+
+func getAudioSettings(ctx context.Context, client *SoapClient) (AudioSettings, error) {
+	settings := AudioSettings{}
+
+	var err error
+	settings.Muted, err = client.GetMute(ctx)
+	if err != nil {
+		return settings, err
+	}
+	settings.Bass, err = client.GetBass(ctx)
+	if err != nil {
+		return settings, err
+	}
+	settings.Treble, err = client.GetTreble(ctx)
+	if err != nil {
+		return settings, err
+	}
+	settings.Loudness, err = client.GetLoudness(ctx)
+	if err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// makeAudioPanel shows the mute, bass, treble and loudness settings of the
+// selected speaker. They are loaded in the background, the controls don't
+// do anything until then. Loading is cancelled when ctx is, changes run in
+// activity.
+func makeAudioPanel(ctx context.Context, w fyne.Window, activity *ActivityBar) fyne.CanvasObject {
+	device := controller.Device()
+	client := newSoapClient(device.Host)
+
+	showError := func(err error) {
+		showUnlessCancelled(err, w)
+	}
+
+	muteCheck := widget.NewCheck("Mute", nil)
+	loudnessCheck := widget.NewCheck("Loudness", nil)
+
+	bassLabel := widget.NewLabel("0")
+	bassSlider := widget.NewSlider(-10, 10)
+	trebleLabel := widget.NewLabel("0")
+	trebleSlider := widget.NewSlider(-10, 10)
+
+	// Sliders can't be disabled, they only get their handlers once loaded
+	muteCheck.Disable()
+	loudnessCheck.Disable()
+
+	enable := func(settings AudioSettings) {
+		muteCheck.SetChecked(settings.Muted)
+		loudnessCheck.SetChecked(settings.Loudness)
+		bassSlider.Value = float64(settings.Bass)
+		bassSlider.Refresh()
+		bassLabel.SetText(fmt.Sprint(settings.Bass))
+		trebleSlider.Value = float64(settings.Treble)
+		trebleSlider.Refresh()
+		trebleLabel.SetText(fmt.Sprint(settings.Treble))
+
+		muteCheck.OnChanged = func(checked bool) {
+			activity.Run(w, muteMessage(checked, device.Name), func(ctx context.Context) error {
+				return client.SetMute(ctx, checked)
+			})
+		}
+		loudnessCheck.OnChanged = func(checked bool) {
+			activity.Run(w, "Changing the loudness", func(ctx context.Context) error {
+				return client.SetLoudness(ctx, checked)
+			})
+		}
+		bass := newSliderSender(activity, w, "Changing the bass", client.SetBass)
+		bassSlider.OnChanged = func(value float64) {
+			bassLabel.SetText(fmt.Sprint(int(value)))
+			bass.Send(int(value))
+		}
+		treble := newSliderSender(activity, w, "Changing the treble", client.SetTreble)
+		trebleSlider.OnChanged = func(value float64) {
+			trebleLabel.SetText(fmt.Sprint(int(value)))
+			treble.Send(int(value))
+		}
+
+		muteCheck.Enable()
+		loudnessCheck.Enable()
+	}
+
+	if (Device{}) != device {
+		go func() {
+			settings, err := getAudioSettings(ctx, client)
+			if err != nil {
+				showError(err)
+				return
+			}
+			enable(settings)
+		}()
+	}
+
+	return widget.NewForm(
+		widget.NewFormItem("Bass", container.NewBorder(nil, nil, nil, bassLabel, bassSlider)),
+		widget.NewFormItem("Treble", container.NewBorder(nil, nil, nil, trebleLabel, trebleSlider)),
+		widget.NewFormItem("", loudnessCheck),
+		widget.NewFormItem("", muteCheck),
+	)
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SetAVTransportURIRequest struct {
+	InstanceID         int
+	CurrentURI         string
+	CurrentURIMetaData string
+}
+
+type PlayRequest struct {
+	InstanceID int
+	Speed      string
+}
+
+type PauseRequest struct {
+	InstanceID int
+}
+
+type StopRequest struct {
+	InstanceID int
+}
+
+type SeekRequest struct {
+	InstanceID int
+	Unit       string
+	Target     string
+}
+
+type AddURIToQueueRequest struct {
+	InstanceID                      int
+	EnqueuedURI                     string
+	EnqueuedURIMetaData             string
+	DesiredFirstTrackNumberEnqueued int
+	EnqueueAsNext                   int
+}
+
+type AddURIToQueueResponse struct {
+	FirstTrackNumberEnqueued int
+	NumTracksAdded           int
+	NewQueueLength           int
+}
+
+type RemoveTrackFromQueueRequest struct {
+	InstanceID int
+	ObjectID   string
+	UpdateID   int
+}
+
+type ReorderTracksInQueueRequest struct {
+	InstanceID     int
+	StartingIndex  int
+	NumberOfTracks int
+	InsertBefore   int
+	UpdateID       int
+}
+
+type RemoveAllTracksFromQueueRequest struct {
+	InstanceID int
+}
+
+type NextRequest struct {
+	InstanceID int
+}
+
+type PreviousRequest struct {
+	InstanceID int
+}
+
+type GetPositionInfoRequest struct {
+	InstanceID int
+}
+
+type GetPositionInfoResponse struct {
+	Track         int
+	TrackDuration string
+	TrackMetaData string
+	TrackURI      string
+	RelTime       string
+	AbsTime       string
+}
+
+type GetTransportInfoRequest struct {
+	InstanceID int
+}
+
+type GetTransportInfoResponse struct {
+	CurrentTransportState  string
+	CurrentTransportStatus string
+	CurrentSpeed           string
+}
+
+func (c *SoapClient) SetAVTransportURI(ctx context.Context, uri string, metaData string) error {
+	req := SetAVTransportURIRequest{CurrentURI: uri, CurrentURIMetaData: metaData}
+	return c.Call(ctx, avTransportService, "SetAVTransportURI", req, nil)
+}
+
+func (c *SoapClient) Play(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "Play", PlayRequest{Speed: "1"}, nil)
+}
+
+func (c *SoapClient) Pause(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "Pause", PauseRequest{}, nil)
+}
+
+func (c *SoapClient) Stop(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "Stop", StopRequest{}, nil)
+}
+
+func (c *SoapClient) Seek(ctx context.Context, seconds int) error {
+	req := SeekRequest{Unit: "REL_TIME", Target: formatHMS(seconds)}
+	return c.Call(ctx, avTransportService, "Seek", req, nil)
+}
+
+func (c *SoapClient) Next(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "Next", NextRequest{}, nil)
+}
+
+func (c *SoapClient) Previous(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "Previous", PreviousRequest{}, nil)
+}
+
+// SeekTrack jumps to the queue track with the given 1-based number.
+func (c *SoapClient) SeekTrack(ctx context.Context, number int) error {
+	req := SeekRequest{Unit: "TRACK_NR", Target: strconv.Itoa(number)}
+	return c.Call(ctx, avTransportService, "Seek", req, nil)
+}
+
+// AddURIToQueue appends uri to the end of the speaker queue and returns the
+// 1-based track number it was given.
+func (c *SoapClient) AddURIToQueue(ctx context.Context, uri string, metaData string) (int, error) {
+	req := AddURIToQueueRequest{EnqueuedURI: uri, EnqueuedURIMetaData: metaData}
+	resp := AddURIToQueueResponse{}
+	err := c.Call(ctx, avTransportService, "AddURIToQueue", req, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.FirstTrackNumberEnqueued, nil
+}
+
+func (c *SoapClient) RemoveTrackFromQueue(ctx context.Context, number int) error {
+	req := RemoveTrackFromQueueRequest{ObjectID: fmt.Sprintf("Q:0/%d", number)}
+	return c.Call(ctx, avTransportService, "RemoveTrackFromQueue", req, nil)
+}
+
+// ReorderTracksInQueue moves the track with 1-based number from so that it
+// ends up in front of the track currently numbered insertBefore.
+func (c *SoapClient) ReorderTracksInQueue(ctx context.Context, from int, insertBefore int) error {
+	req := ReorderTracksInQueueRequest{StartingIndex: from, NumberOfTracks: 1, InsertBefore: insertBefore}
+	return c.Call(ctx, avTransportService, "ReorderTracksInQueue", req, nil)
+}
+
+func (c *SoapClient) RemoveAllTracksFromQueue(ctx context.Context) error {
+	return c.Call(ctx, avTransportService, "RemoveAllTracksFromQueue", RemoveAllTracksFromQueueRequest{}, nil)
+}
+
+func (c *SoapClient) GetPositionInfo(ctx context.Context) (GetPositionInfoResponse, error) {
+	resp := GetPositionInfoResponse{}
+	err := c.Call(ctx, avTransportService, "GetPositionInfo", GetPositionInfoRequest{}, &resp)
+	return resp, err
+}
+
+func (c *SoapClient) GetTransportInfo(ctx context.Context) (GetTransportInfoResponse, error) {
+	resp := GetTransportInfoResponse{}
+	err := c.Call(ctx, avTransportService, "GetTransportInfo", GetTransportInfoRequest{}, &resp)
+	return resp, err
+}
+
+func formatHMS(seconds int) string {
+	hour := int(seconds / 3600)
+	minute := int(seconds/60) % 60
+	second := seconds % 60
+	return fmt.Sprintf("%02d:%02d:%02d", hour, minute, second)
+}
+
+// parseHMS parses the H+:MM:SS[.F+] durations AVTransport reports. Values
+// like "NOT_IMPLEMENTED" or an empty string give an error.
+func parseHMS(hms string) (int, error) {
+	hms, _, _ = strings.Cut(hms, ".")
+	parts := strings.Split(hms, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid duration %q", hms)
+	}
+
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", hms)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
@@ -0,0 +1,379 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `Usage: yousonos [-device name] <command> [arguments]
+
+Without any arguments the window is opened.
+
+Commands:
+  help               show this help
+  devices            list the speakers on the network
+  play [-no-wait] <url>
+                     play a YouTube video, serving the stream until it ends
+  pause              pause playback
+  stop               stop playback
+  seek <position>    seek to a position like 83, 1:23 or 1:01:23
+  volume [volume]    show or set the volume
+  status [-json]     show what is playing
+
+The speaker defaults to the one selected in the settings, or the only one
+on the network.
+
+play serves the stream on port 9372 like the window does, so it can't be
+used while the window is open. Use the window or its HTTP API instead.
+`
+
+type cliCommand struct {
+	run func(ctx context.Context, args []string, stdout io.Writer) error
+	// needsDevice commands get a device selected on the controller before running
+	needsDevice bool
+}
+
+var cliCommands = map[string]cliCommand{
+	"devices": {run: cliDevices},
+	"play":    {run: cliPlay, needsDevice: true},
+	"pause":   {run: cliPause, needsDevice: true},
+	"stop":    {run: cliStop, needsDevice: true},
+	"seek":    {run: cliSeek, needsDevice: true},
+	"volume":  {run: cliVolume, needsDevice: true},
+	"status":  {run: cliStatus, needsDevice: true},
+}
+
+// runCliWithStoredPreferences runs runCli with the preferences the window
+// stored.
+func runCliWithStoredPreferences(args []string) int {
+	path, err := preferencesPath(appId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "yousonos: could not find the settings: %s\n", err)
+		return 1
+	}
+	prefs, err := loadFilePreferences(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "yousonos: could not read the settings: %s\n", err)
+		return 1
+	}
+	return runCli(prefs, args)
+}
+
+// runCli runs YouSonos as a command line tool and returns the exit code.
+func runCli(prefs Preferences, args []string) int {
+	flags := flag.NewFlagSet("yousonos", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, cliUsage)
+	}
+	deviceName := flags.String("device", prefs.String("ActiveDevice"), "name, room or address of the speaker")
+
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	if flags.Arg(0) == "help" {
+		fmt.Fprint(os.Stdout, cliUsage)
+		return 0
+	}
+
+	command, ok := cliCommands[flags.Arg(0)]
+	if !ok {
+		if flags.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "yousonos: expected a command")
+		} else {
+			fmt.Fprintf(os.Stderr, "yousonos: unknown command %q\n", flags.Arg(0))
+		}
+		flags.Usage()
+		return 2
+	}
+
+	// Unlike the window this doesn't load the stored streams, so streams are
+	// only kept in memory. The window would overwrite them in its file.
+	loadSettings(prefs)
+
+	// Interrupting gives up on whatever the command is waiting for
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = discovery.Search(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "yousonos: %s\n", err)
+		return 1
+	}
+
+	if command.needsDevice {
+		device, err := findCliDevice(*deviceName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "yousonos: %s\n", err)
+			return 1
+		}
+		controller.SelectDevice(device)
+
+		// Transport commands have to go to the group coordinator
+		err = topology.Refresh(ctx, device.Host)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "yousonos: could not get zone groups: %s\n", err)
+		}
+	}
+
+	err = command.run(ctx, flags.Args()[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "yousonos %s: %s\n", flags.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+// findCliDevice finds a speaker by its full name, room name or address.
+// Without a name the only speaker on the network is used.
+func findCliDevice(name string) (Device, error) {
+	devices := discovery.Devices()
+
+	if name == "" {
+		if len(devices) == 1 {
+			return devices[0], nil
+		}
+		return Device{}, fmt.Errorf("found %d speakers, choose one with -device", len(devices))
+	}
+
+	for _, device := range devices {
+		room, _, _ := strings.Cut(device.Name, " (")
+		if strings.EqualFold(device.Name, name) || strings.EqualFold(room, name) {
+			return device, nil
+		}
+		if device.Host == name || strings.TrimPrefix(device.Host, "http://") == name || strings.HasPrefix(device.Host, "http://"+name+":") {
+			return device, nil
+		}
+	}
+	return Device{}, fmt.Errorf("could not find speaker %q", name)
+}
+
+func cliDevices(ctx context.Context, args []string, stdout io.Writer) error {
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	for _, device := range discovery.Devices() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", device.Name, strings.TrimPrefix(device.Host, "http://"), device.UDN)
+	}
+	return tw.Flush()
+}
+
+func cliPlay(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("play", flag.ContinueOnError)
+	noWait := flags.Bool("no-wait", false, "exit once playback started instead of serving the stream")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a single URL")
+	}
+
+	err = redirector()
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("port %d is in use, is the YouSonos window open? Play from the window or its HTTP API instead", redirectorPort)
+	}
+	if err != nil {
+		return err
+	}
+
+	track, err := controller.Load(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	err = controller.Play(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Playing %s (%s)\n", track.Title, track.YtId)
+
+	if *noWait {
+		return nil
+	}
+
+	// The speaker fetches the stream from the redirector, so keep serving
+	// it until the speaker stopped or moved on to something else
+	finished := false
+	var uri string
+	tracker := &PositionTracker{}
+	tracker.OnUpdate = func(status PlaybackStatus) {
+		if uri == "" {
+			uri = status.Uri
+		}
+		if status.Uri != uri || status.State == transportNoMedia {
+			finished = true
+		}
+	}
+	tracker.OnStopped = func(status PlaybackStatus) {
+		finished = true
+	}
+
+	for !finished {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+
+		err := tracker.Poll(ctx, controller.TransportDevice().Host)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cliPause(ctx context.Context, args []string, stdout io.Writer) error {
+	return controller.Pause(ctx)
+}
+
+func cliStop(ctx context.Context, args []string, stdout io.Writer) error {
+	return controller.Stop(ctx)
+}
+
+func cliSeek(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("expected a position")
+	}
+
+	seconds, err := parseSeekTarget(args[0])
+	if err != nil {
+		return err
+	}
+	return controller.Seek(ctx, seconds)
+}
+
+// parseSeekTarget parses a position given as seconds, M:SS or H:MM:SS.
+func parseSeekTarget(target string) (int, error) {
+	parts := strings.Split(target, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid position %q", target)
+	}
+
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid position %q", target)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+func cliVolume(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		volume, err := controller.Volume(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, volume)
+		return nil
+	}
+
+	volume, err := strconv.Atoi(args[0])
+	if err != nil || volume < 0 || volume > 100 {
+		return fmt.Errorf("invalid volume %q, expected 0 to 100", args[0])
+	}
+	return controller.SetVolume(ctx, volume)
+}
+
+type cliStatusOutput struct {
+	Device   string `json:"device"`
+	State    string `json:"state"`
+	Title    string `json:"title,omitempty"`
+	Track    int    `json:"track"`
+	Position int    `json:"position"`
+	Duration int    `json:"duration"`
+	Volume   int    `json:"volume"`
+}
+
+func cliStatus(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJson := flags.Bool("json", false, "print the status as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	status, err := controller.Poll(ctx)
+	if err != nil {
+		return err
+	}
+
+	volume, err := controller.Volume(ctx)
+	if err != nil {
+		return err
+	}
+
+	output := cliStatusOutput{
+		Device:   controller.Device().Name,
+		State:    status.State,
+		Title:    metaDataTitle(status.MetaData),
+		Track:    status.Track,
+		Position: status.Position,
+		Duration: status.Duration,
+		Volume:   volume,
+	}
+
+	if *asJson {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(output)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Device:\t%s\n", output.Device)
+	fmt.Fprintf(tw, "State:\t%s\n", output.State)
+	if output.Title != "" {
+		fmt.Fprintf(tw, "Title:\t%s\n", output.Title)
+	}
+	fmt.Fprintf(tw, "Position:\t%s / %s\n", formatHMS(output.Position), formatHMS(output.Duration))
+	fmt.Fprintf(tw, "Volume:\t%d%%\n", output.Volume)
+	return tw.Flush()
+}
+
+// metaDataTitle returns the title from DIDL-Lite track metadata.
+func metaDataTitle(metaData string) string {
+	didl := struct {
+		Item struct {
+			Title string `xml:"title"`
+		} `xml:"item"`
+	}{}
+
+	err := xml.Unmarshal([]byte(metaData), &didl)
+	if err != nil {
+		return ""
+	}
+	return didl.Item.Title
+}
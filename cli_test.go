@@ -0,0 +1,58 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunCliUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"help"}, 0},
+		{"help flag", []string{"-h"}, 0},
+		{"device without a command", []string{"-device", "Kitchen"}, 2},
+		{"unknown command", []string{"shuffle"}, 2},
+		{"unknown flag", []string{"-room", "Kitchen", "status"}, 2},
+	}
+
+	for _, test := range tests {
+		if code := runCli(filePreferences{}, test.args); code != test.want {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.want, code)
+		}
+	}
+}
+
+func TestCliPlayPortInUse(t *testing.T) {
+	// The window serving streams already
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(redirectorPort))
+	if err != nil {
+		t.Skipf("port %d is in use: %s", redirectorPort, err)
+	}
+	defer ln.Close()
+
+	err = cliPlay(context.Background(), []string{"https://youtu.be/dQw4w9WgXcQ"}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "window open") {
+		t.Errorf("expected the window to be named as the cause, got %v", err)
+	}
+}
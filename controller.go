@@ -0,0 +1,329 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var errNoDevice = errors.New("no device selected")
+
+// Controller owns the playback state: the selected speaker, its queue and
+// what it is playing. The window, the command line and the control API all
+// go through it instead of talking to the speaker themselves, and every
+// change is published on its event bus. It is safe for concurrent use.
+type Controller struct {
+	mu     sync.Mutex
+	device Device
+	status PlaybackStatus
+
+	topology *ZoneGroupTopology
+	events   *EventBus
+	queue    *Queue
+	tracker  *PositionTracker
+}
+
+var controller = newController(topology, eventBus)
+
+// newController returns a controller without a selected speaker. Groups
+// are looked up in topology and changes are published on events.
+func newController(topology *ZoneGroupTopology, events *EventBus) *Controller {
+	c := &Controller{topology: topology, events: events}
+	c.queue = newQueue(c.TransportDevice, events)
+	c.tracker = &PositionTracker{
+		OnUpdate: c.updateStatus,
+		// The speaker moves on to the next queued track by itself
+		OnTrackChanged: func(status PlaybackStatus) {
+			c.queue.SyncTrack(status.Track)
+		},
+		OnStopped: func(status PlaybackStatus) {
+			c.events.Publish(TrackChanged{})
+		},
+	}
+	return c
+}
+
+// Events returns the bus changes are published on.
+func (c *Controller) Events() *EventBus {
+	return c.events
+}
+
+// Queue returns the play queue of the selected speaker.
+func (c *Controller) Queue() *Queue {
+	return c.queue
+}
+
+// Device returns the selected speaker, the zero Device if there is none.
+func (c *Controller) Device() Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.device
+}
+
+func (c *Controller) HasDevice() bool {
+	return (Device{}) != c.Device()
+}
+
+// SelectDevice makes device the selected speaker. The queue belongs to the
+// previous speaker, so it is reset unless device is the same speaker at a
+// new address. Subscribing to its events is up to the caller.
+func (c *Controller) SelectDevice(device Device) {
+	c.mu.Lock()
+	previous := c.device
+	c.device = device
+	c.status = PlaybackStatus{}
+	c.mu.Unlock()
+
+	if previous.UDN != device.UDN {
+		c.queue.Reset()
+	}
+}
+
+// TransportDevice returns the device transport commands for the selected
+// speaker go to, the coordinator of its group.
+func (c *Controller) TransportDevice() Device {
+	return c.topology.Coordinator(c.Device())
+}
+
+// transport returns a client for the group coordinator.
+func (c *Controller) transport() (*SoapClient, error) {
+	device := c.TransportDevice()
+	if (Device{}) == device {
+		return nil, errNoDevice
+	}
+	return newSoapClient(device.Host), nil
+}
+
+// rendering returns a client for the selected speaker itself, which is
+// where the volume is set.
+func (c *Controller) rendering() (*SoapClient, error) {
+	device := c.Device()
+	if (Device{}) == device {
+		return nil, errNoDevice
+	}
+	return newSoapClient(device.Host), nil
+}
+
+// Status returns the last known state of the speaker.
+func (c *Controller) Status() PlaybackStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// updateStatus stores status and publishes it when it changed. Polling
+// mostly reports the same thing over and over.
+func (c *Controller) updateStatus(status PlaybackStatus) {
+	c.mu.Lock()
+	changed := status != c.status
+	c.status = status
+	c.mu.Unlock()
+
+	if changed {
+		c.events.Publish(TransportStateChanged{Status: status})
+	}
+}
+
+// setState updates the state right after a command, instead of waiting
+// for the next poll to pick it up.
+func (c *Controller) setState(state string) {
+	status := c.Status()
+	status.State = state
+	c.updateStatus(status)
+}
+
+// Poll asks the speaker what it is doing.
+func (c *Controller) Poll(ctx context.Context) (PlaybackStatus, error) {
+	device := c.TransportDevice()
+	if (Device{}) == device {
+		return PlaybackStatus{}, errNoDevice
+	}
+
+	err := c.tracker.Poll(ctx, device.Host)
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+	return c.tracker.Status(), nil
+}
+
+// Run polls the selected speaker every interval.
+func (c *Controller) Run(interval time.Duration) {
+	lastErr := ""
+	for range time.Tick(interval) {
+		if !c.HasDevice() {
+			continue
+		}
+
+		// Only log the first of a run of identical errors, the speaker
+		// may be unreachable for a long time
+		_, err := c.Poll(context.Background())
+		if err != nil && err.Error() != lastErr {
+			log.Printf("Could not get playback position: %s", err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+	}
+}
+
+// Resolve looks up the stream of a YouTube url and registers it with the
+// redirector, ready to be played by the selected speaker.
+func (c *Controller) Resolve(ctx context.Context, ytUrl string) (Track, error) {
+	device := c.Device()
+	if (Device{}) == device {
+		return Track{}, errNoDevice
+	}
+	return resolveTrack(ctx, ytUrl, device.Host)
+}
+
+// Load makes the speaker play a YouTube url directly, without the queue.
+func (c *Controller) Load(ctx context.Context, ytUrl string) (Track, error) {
+	track, err := c.Resolve(ctx, ytUrl)
+	if err != nil {
+		return Track{}, err
+	}
+
+	client, err := c.transport()
+	if err != nil {
+		return Track{}, err
+	}
+
+	err = client.SetAVTransportURI(ctx, track.Uri, track.MetaData)
+	if err != nil {
+		return Track{}, err
+	}
+	return track, nil
+}
+
+// PlayUrl adds a YouTube url to the queue and starts playing it.
+func (c *Controller) PlayUrl(ctx context.Context, ytUrl string) (Track, error) {
+	track, index, err := c.enqueue(ctx, ytUrl)
+	if err != nil {
+		return Track{}, err
+	}
+
+	err = c.queue.PlayIndex(ctx, index)
+	if err != nil {
+		return Track{}, err
+	}
+	return track, nil
+}
+
+// Enqueue adds a YouTube url to the end of the queue.
+func (c *Controller) Enqueue(ctx context.Context, ytUrl string) (Track, error) {
+	track, _, err := c.enqueue(ctx, ytUrl)
+	return track, err
+}
+
+func (c *Controller) enqueue(ctx context.Context, ytUrl string) (Track, int, error) {
+	track, err := c.Resolve(ctx, ytUrl)
+	if err != nil {
+		return Track{}, 0, err
+	}
+
+	index, err := c.queue.Add(ctx, track)
+	if err != nil {
+		return Track{}, 0, err
+	}
+	return track, index, nil
+}
+
+func (c *Controller) Play(ctx context.Context) error {
+	client, err := c.transport()
+	if err != nil {
+		return err
+	}
+
+	err = client.Play(ctx)
+	if err != nil {
+		return err
+	}
+	c.setState(transportPlaying)
+	return nil
+}
+
+func (c *Controller) Pause(ctx context.Context) error {
+	client, err := c.transport()
+	if err != nil {
+		return err
+	}
+
+	err = client.Pause(ctx)
+	if err != nil {
+		return err
+	}
+	c.setState(transportPaused)
+	return nil
+}
+
+func (c *Controller) Stop(ctx context.Context) error {
+	client, err := c.transport()
+	if err != nil {
+		return err
+	}
+
+	err = client.Stop(ctx)
+	if err != nil {
+		return err
+	}
+	c.setState(transportStopped)
+	c.events.Publish(TrackChanged{})
+	return nil
+}
+
+func (c *Controller) Seek(ctx context.Context, seconds int) error {
+	client, err := c.transport()
+	if err != nil {
+		return err
+	}
+	return client.Seek(ctx, seconds)
+}
+
+func (c *Controller) Volume(ctx context.Context) (int, error) {
+	client, err := c.rendering()
+	if err != nil {
+		return 0, err
+	}
+	return client.GetVolume(ctx)
+}
+
+func (c *Controller) SetVolume(ctx context.Context, volume int) error {
+	client, err := c.rendering()
+	if err != nil {
+		return err
+	}
+
+	err = client.SetVolume(ctx, volume)
+	if err != nil {
+		return err
+	}
+	c.events.Publish(VolumeChanged{Volume: volume})
+	return nil
+}
+
+// RefreshVolume publishes the volume of the selected speaker.
+func (c *Controller) RefreshVolume(ctx context.Context) error {
+	volume, err := c.Volume(ctx)
+	if err != nil {
+		return err
+	}
+	c.events.Publish(VolumeChanged{Volume: volume})
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recordEvents collects what c publishes until the test ends.
+func recordEvents(t *testing.T, c *Controller) func() []Event {
+	var mu sync.Mutex
+	var events []Event
+	t.Cleanup(c.Events().Watch(func(event Event) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+
+	return func() []Event {
+		mu.Lock()
+		defer mu.Unlock()
+
+		recorded := events
+		events = nil
+		return recorded
+	}
+}
+
+func TestControllerWithoutDevice(t *testing.T) {
+	ctx := context.Background()
+	c := newController(&ZoneGroupTopology{}, &EventBus{})
+
+	actions := map[string]func(ctx context.Context) error{
+		"play":  c.Play,
+		"pause": c.Pause,
+		"stop":  c.Stop,
+		"seek":  func(ctx context.Context) error { return c.Seek(ctx, 10) },
+		"poll": func(ctx context.Context) error {
+			_, err := c.Poll(ctx)
+			return err
+		},
+		"volume": func(ctx context.Context) error {
+			_, err := c.Volume(ctx)
+			return err
+		},
+		"set volume": func(ctx context.Context) error { return c.SetVolume(ctx, 10) },
+		"enqueue": func(ctx context.Context) error {
+			_, err := c.Enqueue(ctx, "https://youtu.be/dQw4w9WgXcQ")
+			return err
+		},
+	}
+
+	for name, action := range actions {
+		err := action(ctx)
+		if !errors.Is(err, errNoDevice) {
+			t.Errorf("%s: expected %q, got %v", name, errNoDevice, err)
+		}
+	}
+}
+
+func TestControllerEvents(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Hallway")
+	c := newTestController(speaker)
+	events := recordEvents(t, c)
+
+	err := newSoapClient(c.TransportDevice().Host).SetAVTransportURI(ctx, "http://127.0.0.1:9372/test.mp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Play(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded := events(); len(recorded) != 1 || recorded[0].(TransportStateChanged).Status.State != transportPlaying {
+		t.Fatalf("expected the new state to be published right away, got %v", recorded)
+	}
+
+	// Polling the same state again isn't news
+	for i := 0; i < 3; i++ {
+		pollStatus(t, c)
+	}
+	if recorded := events(); len(recorded) != 1 {
+		t.Fatalf("expected a single update with the polled position, got %v", recorded)
+	}
+
+	err = c.SetVolume(ctx, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded := events(); len(recorded) != 1 || recorded[0] != (VolumeChanged{Volume: 50}) {
+		t.Fatalf("expected the volume to be published, got %v", recorded)
+	}
+
+	err = c.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := events()
+	if len(recorded) != 2 || recorded[1] != (TrackChanged{}) {
+		t.Fatalf("expected the state and the end of the track to be published, got %v", recorded)
+	}
+	if c.Status().State != transportStopped {
+		t.Fatalf("expected the controller to know it stopped, got %s", c.Status().State)
+	}
+}
+
+func TestControllerSelectDevice(t *testing.T) {
+	ctx := context.Background()
+	first := newFakeSpeaker(t, "Kitchen")
+	second := newFakeSpeaker(t, "Bathroom")
+	c := newTestController(first)
+
+	_, err := c.Queue().Add(ctx, Track{YtId: "first", Title: "first", Uri: "http://127.0.0.1:9372/first.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same speaker at another address keeps its queue
+	moved := first.Device()
+	moved.Host = "http://127.0.0.2:1400"
+	c.SelectDevice(moved)
+	if len(c.Queue().Items()) != 1 {
+		t.Fatalf("expected the queue to be kept, got %v", c.Queue().Items())
+	}
+
+	c.SelectDevice(second.Device())
+	if len(c.Queue().Items()) != 0 || c.Device() != second.Device() {
+		t.Fatalf("expected an empty queue on %v, got %v on %v", second.Device(), c.Queue().Items(), c.Device())
+	}
+}
+
+// TestControllerConcurrent is meant for -race, the window, the API and the
+// background loops all use the controller at the same time.
+func TestControllerConcurrent(t *testing.T) {
+	ctx := context.Background()
+	first := newFakeSpeaker(t, "Attic")
+	second := newFakeSpeaker(t, "Cellar")
+	c := newTestController(first)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				c.SelectDevice(first.Device())
+			} else {
+				c.SelectDevice(second.Device())
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			c.Poll(ctx)
+			c.Volume(ctx)
+			c.Status()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,439 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ssdpAddress = "239.255.255.250:1900"
+const zonePlayerType = "urn:schemas-upnp-org:device:ZonePlayer:1"
+
+// How long a device is kept without hearing from it when it didn't say.
+const defaultDeviceMaxAge = 30 * time.Minute
+
+type Root struct {
+	Device struct {
+		RoomName    string `xml:"roomName"`
+		DisplayName string `xml:"displayName"`
+		UDN         string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+type discoveredDevice struct {
+	Device
+	expires time.Time
+}
+
+// Discovery keeps the list of speakers on the network up to date. It
+// searches periodically and listens for the ssdp:alive and ssdp:byebye
+// announcements speakers multicast when they come and go. Devices are
+// tracked by UDN, so a speaker that got another address is updated in
+// place instead of showing up twice.
+type Discovery struct {
+	mu       sync.Mutex
+	devices  map[string]discoveredDevice
+	watchers map[int]func()
+	nextId   int
+
+	Client *http.Client
+	// SearchAddress is where M-SEARCH requests are sent, the SSDP
+	// multicast address when empty.
+	SearchAddress string
+}
+
+var discovery = &Discovery{}
+
+func (d *Discovery) client() *http.Client {
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
+
+// Devices returns the known devices sorted by name.
+func (d *Discovery) Devices() []Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	devices := make([]Device, 0, len(d.devices))
+	for _, device := range d.devices {
+		devices = append(devices, device.Device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+// Find returns the device with the given name.
+func (d *Discovery) Find(name string) (Device, bool) {
+	for _, device := range d.Devices() {
+		if device.Name == name {
+			return device, true
+		}
+	}
+	return Device{}, false
+}
+
+// FindUDN returns the device with the given UDN.
+func (d *Discovery) FindUDN(udn string) (Device, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	device, ok := d.devices[udn]
+	return device.Device, ok
+}
+
+// Watch calls fn whenever a device appears, disappears or changes. The
+// returned function stops watching.
+func (d *Discovery) Watch(fn func()) func() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.watchers == nil {
+		d.watchers = make(map[int]func())
+	}
+	id := d.nextId
+	d.nextId++
+	d.watchers[id] = fn
+
+	return func() {
+		d.mu.Lock()
+		delete(d.watchers, id)
+		d.mu.Unlock()
+	}
+}
+
+func (d *Discovery) changed() {
+	d.mu.Lock()
+	watchers := make([]func(), 0, len(d.watchers))
+	for _, fn := range d.watchers {
+		watchers = append(watchers, fn)
+	}
+	d.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+}
+
+// update adds or refreshes the device at location. The description is only
+// fetched for devices that are new or moved.
+func (d *Discovery) update(ctx context.Context, location string, udn string, maxAge time.Duration) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	host := "http://" + u.Host
+
+	d.mu.Lock()
+	known, ok := d.devices[udn]
+	if ok && udn != "" && known.Host == host {
+		known.expires = time.Now().Add(maxAge)
+		d.devices[udn] = known
+		d.mu.Unlock()
+		return nil
+	}
+	d.mu.Unlock()
+
+	device, err := d.describe(ctx, location)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if d.devices == nil {
+		d.devices = make(map[string]discoveredDevice)
+	}
+	d.devices[device.UDN] = discoveredDevice{Device: device, expires: time.Now().Add(maxAge)}
+	d.mu.Unlock()
+
+	d.changed()
+	return nil
+}
+
+func (d *Discovery) describe(ctx context.Context, location string) (Device, error) {
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Discovery)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return Device{}, err
+	}
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return Device{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Device{}, err
+	}
+
+	root := Root{}
+
+	err = xml.Unmarshal(bodyBytes, &root)
+	if err != nil {
+		return Device{}, err
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return Device{}, err
+	}
+
+	name := fmt.Sprintf("%s (%s)", root.Device.RoomName, root.Device.DisplayName)
+	return Device{
+		Name: name,
+		Host: "http://" + u.Host,
+		UDN:  root.Device.UDN,
+	}, nil
+}
+
+func (d *Discovery) remove(udn string) {
+	d.mu.Lock()
+	_, ok := d.devices[udn]
+	delete(d.devices, udn)
+	d.mu.Unlock()
+
+	if ok {
+		d.changed()
+	}
+}
+
+// expire forgets devices that neither answered a search nor announced
+// themselves within their max-age.
+func (d *Discovery) expire() {
+	removed := false
+
+	d.mu.Lock()
+	for udn, device := range d.devices {
+		if time.Now().After(device.expires) {
+			delete(d.devices, udn)
+			removed = true
+		}
+	}
+	d.mu.Unlock()
+
+	if removed {
+		d.changed()
+	}
+}
+
+// Search sends an M-SEARCH and adds every speaker that answers.
+func (d *Discovery) Search(ctx context.Context) error {
+	address := d.SearchAddress
+	if address == "" {
+		address = ssdpAddress
+	}
+
+	responses, err := searchDevices(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	for _, header := range responses {
+		err := d.update(ctx, header.Get("Location"), usnDevice(header.Get("USN")), ssdpMaxAge(header))
+		if err != nil {
+			log.Printf("Could not describe device at %s: %s", header.Get("Location"), err)
+		}
+	}
+
+	d.expire()
+	return nil
+}
+
+// Run searches every interval.
+func (d *Discovery) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := d.Search(context.Background())
+		if err != nil {
+			log.Printf("Could not search for devices: %s", err)
+		}
+	}
+}
+
+// Listen handles the NOTIFY announcements multicast by speakers. It only
+// returns when joining the multicast group failed.
+func (d *Discovery) Listen() error {
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "NOTIFY" {
+			continue
+		}
+
+		d.handleNotify(req.Header)
+	}
+}
+
+func (d *Discovery) handleNotify(header http.Header) {
+	if header.Get("NT") != zonePlayerType {
+		return
+	}
+
+	udn := usnDevice(header.Get("USN"))
+	if udn == "" {
+		return
+	}
+
+	switch header.Get("NTS") {
+	case "ssdp:alive":
+		err := d.update(context.Background(), header.Get("Location"), udn, ssdpMaxAge(header))
+		if err != nil {
+			log.Printf("Could not describe device at %s: %s", header.Get("Location"), err)
+		}
+	case "ssdp:byebye":
+		d.remove(udn)
+	}
+}
+
+// usnDevice returns the device UDN part of a USN like
+// uuid:RINCON_000E58XXXXXXXX::urn:schemas-upnp-org:device:ZonePlayer:1.
+func usnDevice(usn string) string {
+	udn, _, _ := strings.Cut(usn, "::")
+	return udn
+}
+
+func ssdpMaxAge(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultDeviceMaxAge
+}
+
+// searchDevices collects the answers to an M-SEARCH for the speakers' MX
+// wait, or until ctx is done if that is sooner.
+func searchDevices(ctx context.Context, address string) ([]http.Header, error) {
+	query := zonePlayerType
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := strings.Join([]string{
+		"M-SEARCH * HTTP/1.1",
+		"HOST: " + address,
+		"MAN: \"ssdp:discover\"",
+		"ST: " + query,
+		"MX: 1",
+		"", "",
+	}, "\r\n")
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.WriteTo([]byte(req), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	// Cancelling interrupts the read below
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var devices []http.Header
+	for {
+		buf := make([]byte, 65536)
+
+		n, _, err := conn.ReadFrom(buf)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			break
+		} else if err != nil {
+			log.Printf("ReadFrom error: %s", err)
+			break
+		}
+
+		r := bufio.NewReader(bytes.NewReader(buf[:n]))
+
+		resp, err := http.ReadResponse(r, &http.Request{})
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		for _, head := range resp.Header["St"] {
+			if head == query {
+				devices = append(devices, resp.Header)
+				break
+			}
+		}
+	}
+
+	// Running into the deadline of ctx just ends the wait early
+	if ctx.Err() == context.Canceled {
+		return nil, ctx.Err()
+	}
+	return devices, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Event is a change published on the event bus.
+type Event interface {
+	EventName() string
+}
+
+// TrackChanged is published when another track became current. Track is
+// nil when nothing is playing anymore.
+type TrackChanged struct {
+	Track *Track
+}
+
+// TransportStateChanged is published when the state or position of the
+// selected speaker changed.
+type TransportStateChanged struct {
+	Status PlaybackStatus
+}
+
+// VolumeChanged is published when the volume of the selected speaker changed.
+type VolumeChanged struct {
+	Volume int
+}
+
+// DeviceListChanged is published when speakers came or went, or another
+// one was selected.
+type DeviceListChanged struct {
+	Devices  []Device
+	Selected Device
+}
+
+// QueueChanged is published after the queue contents or the current track
+// changed.
+type QueueChanged struct {
+	Current int
+	Items   []Track
+}
+
+func (TrackChanged) EventName() string          { return "TrackChanged" }
+func (TransportStateChanged) EventName() string { return "TransportStateChanged" }
+func (VolumeChanged) EventName() string         { return "VolumeChanged" }
+func (DeviceListChanged) EventName() string     { return "DeviceListChanged" }
+func (QueueChanged) EventName() string          { return "QueueChanged" }
+
+// EventBus hands every published event to the watchers. It remembers the
+// latest event of each kind, so someone who starts watching late can catch
+// up on the current state first.
+type EventBus struct {
+	mu       sync.Mutex
+	latest   map[string]Event
+	watchers map[int]func(event Event)
+	nextId   int
+}
+
+var eventBus = &EventBus{}
+
+// Watch calls fn with every event published from now on, until the
+// returned function is called.
+func (b *EventBus) Watch(fn func(event Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.watchers == nil {
+		b.watchers = make(map[int]func(event Event))
+	}
+	id := b.nextId
+	b.nextId++
+	b.watchers[id] = fn
+
+	return func() {
+		b.mu.Lock()
+		delete(b.watchers, id)
+		b.mu.Unlock()
+	}
+}
+
+// Latest returns the last event of every kind, ordered by name.
+func (b *EventBus) Latest() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make([]Event, 0, len(b.latest))
+	for _, event := range b.latest {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].EventName() < events[j].EventName()
+	})
+	return events
+}
+
+func (b *EventBus) Publish(event Event) {
+	b.mu.Lock()
+	if b.latest == nil {
+		b.latest = make(map[string]Event)
+	}
+	b.latest[event.EventName()] = event
+
+	watchers := make([]func(event Event), 0, len(b.watchers))
+	for _, fn := range b.watchers {
+		watchers = append(watchers, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(event)
+	}
+}
+
+// publishDevices publishes the current speakers and the selected one.
+func publishDevices() {
+	eventBus.Publish(DeviceListChanged{Devices: discovery.Devices(), Selected: controller.Device()})
+}
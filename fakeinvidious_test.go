@@ -0,0 +1,161 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const invidiousFixtures = "testdata/invidious"
+
+// fakeInvidious is an Invidious instance serving the responses recorded in
+// testdata/invidious. Like the real thing it answers errors about a video,
+// such as it being private, with a JSON error and status 500.
+type fakeInvidious struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// Status makes every request fail with this status when set, the way
+	// an overloaded or broken instance does.
+	Status   int
+	requests []string
+	searches []url.Values
+}
+
+func newFakeInvidious(t *testing.T) *fakeInvidious {
+	t.Helper()
+
+	f := &fakeInvidious{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/videos/", f.video)
+	mux.HandleFunc("/api/v1/stats", f.stats)
+	mux.HandleFunc("/api/v1/search", f.search)
+	f.server = httptest.NewServer(f.record(mux))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeInvidious) URL() string {
+	return f.server.URL
+}
+
+// Requests returns the paths requested so far.
+func (f *fakeInvidious) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make([]string, len(f.requests))
+	copy(requests, f.requests)
+	return requests
+}
+
+// Searches returns the query parameters of the searches so far.
+func (f *fakeInvidious) Searches() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	searches := make([]url.Values, len(f.searches))
+	copy(searches, f.searches)
+	return searches
+}
+
+func (f *fakeInvidious) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.URL.Path)
+		status := f.Status
+		f.mu.Unlock()
+
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *fakeInvidious) video(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/videos/")
+	if id == "" || strings.ContainsAny(id, "/.") {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := os.ReadFile(filepath.Join(invidiousFixtures, "videos", id+".json"))
+	if os.IsNotExist(err) {
+		body = []byte(`{"error":"This video is unavailable"}`)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	serveFixture(w, body)
+}
+
+func (f *fakeInvidious) stats(w http.ResponseWriter, r *http.Request) {
+	body, err := os.ReadFile(filepath.Join(invidiousFixtures, "stats.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveFixture(w, body)
+}
+
+// search answers every query with the results in search.json, which has
+// channels and playlists mixed in the way Invidious returns them. There is
+// only a single page.
+func (f *fakeInvidious) search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	f.mu.Lock()
+	f.searches = append(f.searches, params)
+	f.mu.Unlock()
+
+	if params.Get("q") == "" {
+		serveFixture(w, []byte(`{"error":"Search query cannot be empty"}`))
+		return
+	}
+	if params.Get("page") != "" && params.Get("page") != "1" {
+		serveFixture(w, []byte("[]"))
+		return
+	}
+
+	body, err := os.ReadFile(filepath.Join(invidiousFixtures, "search.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveFixture(w, body)
+}
+
+func serveFixture(w http.ResponseWriter, body []byte) {
+	apiErr := struct {
+		Error string `json:"error"`
+	}{}
+	json.Unmarshal(body, &apiErr)
+
+	w.Header().Set("Content-Type", "application/json")
+	if apiErr.Error != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(body)
+}
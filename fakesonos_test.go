@@ -0,0 +1,542 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSpeaker is a ZonePlayer that runs on loopback. It answers M-SEARCH
+// requests sent to ssdpAddress, serves its device description and handles
+// the AVTransport and RenderingControl actions YouSonos uses, sending GENA
+// events to subscribers whenever its state changes. The position only
+// changes by seeking, so tests don't depend on timing.
+type fakeSpeaker struct {
+	t   *testing.T
+	udn string
+
+	RoomName    string
+	DisplayName string
+
+	server      *httptest.Server
+	ssdp        net.PacketConn
+	ssdpAddress string
+
+	mu       sync.Mutex
+	state    string
+	uri      string
+	metaData string
+	queue    []string
+	track    int
+	position int
+	duration int
+	volume   int
+	muted    bool
+	subs     map[string]fakeSubscription
+	nextSid  int
+
+	// Actions records the name of every action called, in order.
+	Actions []string
+}
+
+type fakeSubscription struct {
+	service  Service
+	callback string
+	seq      int
+}
+
+type fakeArgs map[string]string
+
+// fakeFault is returned by action handlers to answer with a UPnP error.
+type fakeFault struct {
+	code int
+}
+
+func (f *fakeFault) Error() string {
+	return fmt.Sprintf("UPnP error %d", f.code)
+}
+
+var fakeUdnCount int
+
+// newFakeSpeaker starts a speaker that is stopped until the test ends.
+func newFakeSpeaker(t *testing.T, roomName string) *fakeSpeaker {
+	t.Helper()
+
+	fakeUdnCount++
+	s := &fakeSpeaker{
+		t:           t,
+		udn:         fmt.Sprintf("uuid:RINCON_FAKE%08d01400", fakeUdnCount),
+		RoomName:    roomName,
+		DisplayName: "Play:1",
+		state:       transportNoMedia,
+		volume:      20,
+		subs:        make(map[string]fakeSubscription),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/xml/device_description.xml", s.describe)
+	mux.HandleFunc(avTransportService.ControlPath, s.control(avTransportService))
+	mux.HandleFunc(renderingControlService.ControlPath, s.control(renderingControlService))
+	mux.HandleFunc(avTransportService.EventPath, s.event(avTransportService))
+	mux.HandleFunc(renderingControlService.EventPath, s.event(renderingControlService))
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	ssdp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ssdp = ssdp
+	s.ssdpAddress = ssdp.LocalAddr().String()
+	t.Cleanup(func() {
+		ssdp.Close()
+	})
+	go s.answerSearches()
+
+	return s
+}
+
+// Device returns the speaker the way discovery would report it.
+func (s *fakeSpeaker) Device() Device {
+	return Device{
+		Name: fmt.Sprintf("%s (%s)", s.RoomName, s.DisplayName),
+		Host: s.server.URL,
+		UDN:  s.udn,
+	}
+}
+
+func (s *fakeSpeaker) location() string {
+	return s.server.URL + "/xml/device_description.xml"
+}
+
+func (s *fakeSpeaker) answerSearches() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := s.ssdp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" {
+			continue
+		}
+		st := req.Header.Get("ST")
+		if st != zonePlayerType && st != "ssdp:all" {
+			continue
+		}
+
+		resp := strings.Join([]string{
+			"HTTP/1.1 200 OK",
+			"CACHE-CONTROL: max-age = 1800",
+			"EXT:",
+			"LOCATION: " + s.location(),
+			"SERVER: Linux UPnP/1.0 Sonos/70.3-88200 (ZPS1)",
+			"ST: " + zonePlayerType,
+			"USN: " + s.udn + "::" + zonePlayerType,
+			"", "",
+		}, "\r\n")
+		s.ssdp.WriteTo([]byte(resp), addr)
+	}
+}
+
+func (s *fakeSpeaker) describe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml; charset=\"utf-8\"")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>%s</deviceType>
+    <friendlyName>127.0.0.1 - Sonos %s</friendlyName>
+    <manufacturer>Sonos, Inc.</manufacturer>
+    <modelName>Sonos %s</modelName>
+    <UDN>%s</UDN>
+    <roomName>%s</roomName>
+    <displayName>%s</displayName>
+  </device>
+</root>`, zonePlayerType, s.DisplayName, s.DisplayName, s.udn, s.RoomName, s.DisplayName)
+}
+
+type fakeEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (s *fakeSpeaker) control(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serviceType, action, _ := strings.Cut(r.Header.Get("SOAPACTION"), "#")
+		if r.Method != "POST" || serviceType != service.Type {
+			http.Error(w, "invalid action", http.StatusBadRequest)
+			return
+		}
+
+		envelope := fakeEnvelope{}
+		err := xml.NewDecoder(r.Body).Decode(&envelope)
+		if err != nil || envelope.Body.Action.XMLName.Local != action {
+			s.fault(w, 401)
+			return
+		}
+
+		args := make(fakeArgs)
+		for _, arg := range envelope.Body.Action.Args {
+			args[arg.XMLName.Local] = arg.Value
+		}
+
+		s.mu.Lock()
+		s.Actions = append(s.Actions, action)
+		var response fakeArgs
+		if service == avTransportService {
+			response, err = s.avTransport(action, args)
+		} else {
+			response, err = s.renderingControl(action, args)
+		}
+		s.mu.Unlock()
+
+		if fault, ok := err.(*fakeFault); ok {
+			s.fault(w, fault.code)
+			return
+		}
+
+		if !strings.HasPrefix(action, "Get") {
+			s.notify(service)
+		}
+
+		var body strings.Builder
+		for name, value := range response {
+			fmt.Fprintf(&body, "<%s>", name)
+			xml.EscapeText(&body, []byte(value))
+			fmt.Fprintf(&body, "</%s>", name)
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=\"utf-8\"")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+			`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+			`<s:Body><u:%sResponse xmlns:u="%s">%s</u:%sResponse></s:Body></s:Envelope>`,
+			action, service.Type, body.String(), action)
+	}
+}
+
+func (s *fakeSpeaker) fault(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/xml; charset=\"utf-8\"")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode></UPnPError></detail>`+
+		`</s:Fault></s:Body></s:Envelope>`, code)
+}
+
+func (args fakeArgs) int(name string) (int, error) {
+	n, err := strconv.Atoi(args[name])
+	if err != nil {
+		return 0, &fakeFault{402}
+	}
+	return n, nil
+}
+
+// avTransport runs an AVTransport action with s.mu held. The transitions
+// follow the AVTransport state machine: nothing can be played before a URI
+// is set, and only a playing speaker can be paused.
+func (s *fakeSpeaker) avTransport(action string, args fakeArgs) (fakeArgs, error) {
+	switch action {
+	case "SetAVTransportURI":
+		s.uri = args["CurrentURI"]
+		s.metaData = args["CurrentURIMetaData"]
+		s.track = 1
+		s.position = 0
+		s.duration = 0
+		s.state = transportStopped
+		if s.uri == "" {
+			s.state = transportNoMedia
+		}
+	case "Play":
+		if s.state == transportNoMedia {
+			return nil, &fakeFault{701}
+		}
+		s.state = transportPlaying
+	case "Pause":
+		if s.state != transportPlaying {
+			return nil, &fakeFault{701}
+		}
+		s.state = transportPaused
+	case "Stop":
+		if s.state == transportNoMedia {
+			return nil, &fakeFault{701}
+		}
+		s.state = transportStopped
+		s.position = 0
+	case "Seek":
+		return nil, s.seek(args)
+	case "Next", "Previous":
+		delta := 1
+		if action == "Previous" {
+			delta = -1
+		}
+		if !s.playingQueue() || s.track+delta < 1 || s.track+delta > len(s.queue) {
+			return nil, &fakeFault{711}
+		}
+		s.track += delta
+		s.position = 0
+	case "AddURIToQueue":
+		s.queue = append(s.queue, args["EnqueuedURI"])
+		return fakeArgs{
+			"FirstTrackNumberEnqueued": strconv.Itoa(len(s.queue)),
+			"NumTracksAdded":           "1",
+			"NewQueueLength":           strconv.Itoa(len(s.queue)),
+		}, nil
+	case "RemoveTrackFromQueue":
+		number, err := strconv.Atoi(strings.TrimPrefix(args["ObjectID"], "Q:0/"))
+		if err != nil || number < 1 || number > len(s.queue) {
+			return nil, &fakeFault{701}
+		}
+		s.queue = append(s.queue[:number-1], s.queue[number:]...)
+		if s.playingQueue() && number < s.track {
+			s.track--
+		}
+	case "RemoveAllTracksFromQueue":
+		s.queue = nil
+		if s.playingQueue() {
+			s.state = transportStopped
+			s.track = 0
+		}
+	case "GetTransportInfo":
+		return fakeArgs{
+			"CurrentTransportState":  s.state,
+			"CurrentTransportStatus": "OK",
+			"CurrentSpeed":           "1",
+		}, nil
+	case "GetPositionInfo":
+		return fakeArgs{
+			"Track":         strconv.Itoa(s.track),
+			"TrackDuration": formatHMS(s.duration),
+			"TrackMetaData": s.metaData,
+			"TrackURI":      s.trackUri(),
+			"RelTime":       formatHMS(s.position),
+			"AbsTime":       "NOT_IMPLEMENTED",
+		}, nil
+	default:
+		return nil, &fakeFault{401}
+	}
+	return nil, nil
+}
+
+func (s *fakeSpeaker) seek(args fakeArgs) error {
+	switch args["Unit"] {
+	case "REL_TIME":
+		if s.state == transportNoMedia {
+			return &fakeFault{701}
+		}
+		seconds, err := parseHMS(args["Target"])
+		if err != nil || (s.duration > 0 && seconds > s.duration) {
+			return &fakeFault{711}
+		}
+		s.position = seconds
+	case "TRACK_NR":
+		number, err := args.int("Target")
+		if err != nil || !s.playingQueue() || number < 1 || number > len(s.queue) {
+			return &fakeFault{711}
+		}
+		s.track = number
+		s.position = 0
+	default:
+		return &fakeFault{710}
+	}
+	return nil
+}
+
+func (s *fakeSpeaker) playingQueue() bool {
+	return strings.HasPrefix(s.uri, "x-rincon-queue:")
+}
+
+func (s *fakeSpeaker) trackUri() string {
+	if !s.playingQueue() {
+		return s.uri
+	}
+	if s.track < 1 || s.track > len(s.queue) {
+		return ""
+	}
+	return s.queue[s.track-1]
+}
+
+// renderingControl runs a RenderingControl action with s.mu held.
+func (s *fakeSpeaker) renderingControl(action string, args fakeArgs) (fakeArgs, error) {
+	switch action {
+	case "GetVolume", "SetVolume", "GetMute", "SetMute":
+		if args["Channel"] != "Master" {
+			return nil, &fakeFault{402}
+		}
+	default:
+		return nil, &fakeFault{401}
+	}
+
+	switch action {
+	case "GetVolume":
+		return fakeArgs{"CurrentVolume": strconv.Itoa(s.volume)}, nil
+	case "SetVolume":
+		volume, err := args.int("DesiredVolume")
+		if err != nil {
+			return nil, err
+		}
+		if volume < 0 || volume > 100 {
+			return nil, &fakeFault{601}
+		}
+		s.volume = volume
+	case "GetMute":
+		return fakeArgs{"CurrentMute": strconv.Itoa(upnpBool(s.muted))}, nil
+	case "SetMute":
+		s.muted = args["DesiredMute"] == "1"
+	}
+	return nil, nil
+}
+
+// State returns the transport state, position and volume.
+func (s *fakeSpeaker) State() (string, int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.position, s.volume
+}
+
+// SetDuration sets the length of the current track.
+func (s *fakeSpeaker) SetDuration(seconds int) {
+	s.mu.Lock()
+	s.duration = seconds
+	s.mu.Unlock()
+}
+
+func (s *fakeSpeaker) event(service Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch r.Method {
+		case "SUBSCRIBE":
+			sid := r.Header.Get("SID")
+			if sid != "" {
+				// Renewal
+				if _, ok := s.subs[sid]; !ok {
+					http.Error(w, "unknown subscription", http.StatusPreconditionFailed)
+					return
+				}
+			} else {
+				callback := strings.Trim(r.Header.Get("CALLBACK"), "<>")
+				if callback == "" || r.Header.Get("NT") != "upnp:event" {
+					http.Error(w, "missing callback", http.StatusPreconditionFailed)
+					return
+				}
+				s.nextSid++
+				sid = fmt.Sprintf("uuid:%s_sub%010d", strings.TrimPrefix(s.udn, "uuid:"), s.nextSid)
+				s.subs[sid] = fakeSubscription{service: service, callback: callback}
+
+				// The initial event follows the response, like on a real speaker
+				defer func() {
+					go s.notify(service)
+				}()
+			}
+			w.Header().Set("SID", sid)
+			w.Header().Set("TIMEOUT", "Second-1800")
+		case "UNSUBSCRIBE":
+			sid := r.Header.Get("SID")
+			if _, ok := s.subs[sid]; !ok {
+				http.Error(w, "unknown subscription", http.StatusPreconditionFailed)
+				return
+			}
+			delete(s.subs, sid)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// lastChange returns the LastChange document describing the state of
+// service, with s.mu held.
+func (s *fakeSpeaker) lastChange(service Service) string {
+	if service == avTransportService {
+		var metaData strings.Builder
+		xml.EscapeText(&metaData, []byte(s.metaData))
+		return fmt.Sprintf(`<Event xmlns="urn:schemas-upnp-org:metadata-1-0/AVT/"><InstanceID val="0">`+
+			`<TransportState val="%s"/><CurrentTrack val="%d"/><CurrentTrackURI val="%s"/>`+
+			`<CurrentTrackDuration val="%s"/><CurrentTrackMetaData val="%s"/></InstanceID></Event>`,
+			s.state, s.track, s.trackUri(), formatHMS(s.duration), metaData.String())
+	}
+	return fmt.Sprintf(`<Event xmlns="urn:schemas-upnp-org:metadata-1-0/RCS/"><InstanceID val="0">`+
+		`<Volume channel="Master" val="%d"/><Volume channel="LF" val="100"/>`+
+		`<Mute channel="Master" val="%d"/></InstanceID></Event>`,
+		s.volume, upnpBool(s.muted))
+}
+
+// notify sends the current state of service to its subscribers.
+func (s *fakeSpeaker) notify(service Service) {
+	type notification struct {
+		sid      string
+		callback string
+		seq      int
+		body     string
+	}
+
+	s.mu.Lock()
+	var lastChange strings.Builder
+	xml.EscapeText(&lastChange, []byte(s.lastChange(service)))
+	body := `<?xml version="1.0"?><e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">` +
+		`<e:property><LastChange>` + lastChange.String() + `</LastChange></e:property></e:propertyset>`
+
+	var notifications []notification
+	for sid, sub := range s.subs {
+		if sub.service != service {
+			continue
+		}
+		notifications = append(notifications, notification{sid, sub.callback, sub.seq, body})
+		sub.seq++
+		s.subs[sid] = sub
+	}
+	s.mu.Unlock()
+
+	for _, n := range notifications {
+		req, err := http.NewRequest("NOTIFY", n.callback, strings.NewReader(n.body))
+		if err != nil {
+			s.t.Errorf("invalid callback %q: %s", n.callback, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "text/xml; charset=\"utf-8\"")
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", "upnp:propchange")
+		req.Header.Set("SID", n.sid)
+		req.Header.Set("SEQ", strconv.Itoa(n.seq))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const genaCallbackPath = "/upnp/event"
+const genaTimeout = 30 * time.Minute
+
+// Subscription is a GENA event subscription on one speaker service.
+type Subscription struct {
+	Host    string
+	Service Service
+	Sid     string
+	Timeout time.Duration
+	Renewed time.Time
+}
+
+// GenaSubscriber subscribes to UPnP events of a speaker, so changes made
+// from other controllers show up without polling. The speaker delivers the
+// events with NOTIFY requests to ServeHTTP, which is mounted on the
+// redirector.
+type GenaSubscriber struct {
+	mu   sync.Mutex
+	subs map[string]*Subscription
+
+	Client *http.Client
+	// CallbackUrl returns the URL the speaker at host should NOTIFY.
+	CallbackUrl func(host string) (string, error)
+	// OnEvent is called with the changed state variables of an event. The
+	// contents of LastChange are merged in, so AVTransport and
+	// RenderingControl changes show up as e.g. TransportState or Volume.
+	OnEvent func(sub Subscription, values map[string]string)
+}
+
+var genaSubscriber = &GenaSubscriber{CallbackUrl: genaCallbackUrl}
+
+func genaCallbackUrl(host string) (string, error) {
+	localIp, err := localAddressFor(host)
+	if err != nil {
+		return "", err
+	}
+	return "http://" + net.JoinHostPort(localIp, strconv.Itoa(redirectorPort)) + genaCallbackPath, nil
+}
+
+func (g *GenaSubscriber) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+	return g.Client
+}
+
+// Subscribe subscribes to the events of service on the speaker at host.
+func (g *GenaSubscriber) Subscribe(ctx context.Context, host string, service Service) error {
+	callback, err := g.CallbackUrl(host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "SUBSCRIBE", host+service.EventPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("CALLBACK", "<"+callback+">")
+	req.Header.Set("NT", "upnp:event")
+	req.Header.Set("TIMEOUT", formatGenaTimeout(genaTimeout))
+
+	sub := &Subscription{Host: host, Service: service}
+
+	// The speaker sends the initial event as soon as it answered, hold the
+	// lock until the SID is known so ServeHTTP doesn't reject it
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err = g.send(req, sub)
+	if err != nil {
+		return err
+	}
+
+	if g.subs == nil {
+		g.subs = make(map[string]*Subscription)
+	}
+	g.subs[sub.Sid] = sub
+	return nil
+}
+
+// send sends a SUBSCRIBE request and stores the result in sub. The lock is
+// often held meanwhile, so it gives up after Timeouts.Speaker.
+func (g *GenaSubscriber) send(req *http.Request, sub *Subscription) error {
+	ctx, cancel := withTimeout(req.Context(), currentTimeouts().Speaker)
+	defer cancel()
+
+	resp, err := g.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed: %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	sid := resp.Header.Get("SID")
+	if sid == "" {
+		return fmt.Errorf("%s %s failed: no SID in response", req.Method, req.URL.Path)
+	}
+
+	sub.Sid = sid
+	sub.Timeout = parseGenaTimeout(resp.Header.Get("TIMEOUT"))
+	sub.Renewed = time.Now()
+	return nil
+}
+
+// renew extends sub, subscribing again when the speaker forgot about it.
+func (g *GenaSubscriber) renew(ctx context.Context, sub Subscription) error {
+	req, err := http.NewRequestWithContext(ctx, "SUBSCRIBE", sub.Host+sub.Service.EventPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("SID", sub.Sid)
+	req.Header.Set("TIMEOUT", formatGenaTimeout(genaTimeout))
+
+	renewed := sub
+
+	g.mu.Lock()
+	// Unsubscribed in the meantime, e.g. because the speaker was switched
+	if _, ok := g.subs[sub.Sid]; !ok {
+		g.mu.Unlock()
+		return nil
+	}
+	err = g.send(req, &renewed)
+	delete(g.subs, sub.Sid)
+	if err == nil {
+		g.subs[renewed.Sid] = &renewed
+	}
+	g.mu.Unlock()
+
+	if err != nil {
+		return g.Subscribe(ctx, sub.Host, sub.Service)
+	}
+	return nil
+}
+
+// Unsubscribe cancels every subscription.
+func (g *GenaSubscriber) Unsubscribe(ctx context.Context) {
+	g.mu.Lock()
+	subs := g.subs
+	g.subs = make(map[string]*Subscription)
+	g.mu.Unlock()
+
+	for _, sub := range subs {
+		g.unsubscribe(ctx, sub)
+	}
+}
+
+func (g *GenaSubscriber) unsubscribe(ctx context.Context, sub *Subscription) {
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Speaker)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "UNSUBSCRIBE", sub.Host+sub.Service.EventPath, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("SID", sub.Sid)
+
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+type serviceHost struct {
+	Service Service
+	Host    string
+}
+
+// SubscribeDevice replaces the current subscriptions with ones for device.
+// AVTransport and group volume events come from the coordinator of its
+// group, volume events from every member so the mixer stays up to date, and
+// topology events from device itself. Nothing happens when those
+// subscriptions are already in place.
+func (g *GenaSubscriber) SubscribeDevice(ctx context.Context, device Device) error {
+	coordinator := topology.Coordinator(device)
+	wanted := []serviceHost{
+		{avTransportService, coordinator.Host},
+		{groupRenderingControlService, coordinator.Host},
+		{zoneGroupTopologyService, device.Host},
+	}
+
+	group, ok := topology.GroupOf(device.UDN)
+	if ok {
+		for _, member := range group.VisibleMembers() {
+			wanted = append(wanted, serviceHost{renderingControlService, member.Host()})
+		}
+	} else {
+		wanted = append(wanted, serviceHost{renderingControlService, device.Host})
+	}
+
+	current := make(map[serviceHost]bool)
+	for _, sub := range g.Subscriptions() {
+		current[serviceHost{sub.Service, sub.Host}] = true
+	}
+	same := len(current) == len(wanted)
+	for _, want := range wanted {
+		if !current[want] {
+			same = false
+		}
+	}
+	if same {
+		return nil
+	}
+
+	g.Unsubscribe(ctx)
+
+	for _, want := range wanted {
+		err := g.Subscribe(ctx, want.Host, want.Service)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscriptions returns a copy of the active subscriptions.
+func (g *GenaSubscriber) Subscriptions() []Subscription {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(g.subs))
+	for _, sub := range g.subs {
+		subs = append(subs, *sub)
+	}
+	return subs
+}
+
+// RenewDue renews the subscriptions that are past half their timeout.
+func (g *GenaSubscriber) RenewDue(ctx context.Context) {
+	for _, sub := range g.Subscriptions() {
+		if time.Since(sub.Renewed) < sub.Timeout/2 {
+			continue
+		}
+
+		err := g.renew(ctx, sub)
+		if err != nil {
+			log.Printf("Could not renew %s subscription: %s", sub.Service.Type, err)
+		}
+	}
+}
+
+// Run renews the subscriptions every interval.
+func (g *GenaSubscriber) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		g.RenewDue(context.Background())
+	}
+}
+
+func (g *GenaSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	sub, ok := g.subs[r.Header.Get("SID")]
+	var copied Subscription
+	if ok {
+		copied = *sub
+	}
+	g.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown subscription", http.StatusPreconditionFailed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values, err := parsePropertySet(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if g.OnEvent != nil {
+		g.OnEvent(copied, values)
+	}
+}
+
+type genaValue struct {
+	XMLName xml.Name
+	Channel string `xml:"channel,attr"`
+	Val     string `xml:"val,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type propertySet struct {
+	Properties []struct {
+		Values []genaValue `xml:",any"`
+	} `xml:"property"`
+}
+
+type lastChangeEvent struct {
+	InstanceID struct {
+		Values []genaValue `xml:",any"`
+	} `xml:"InstanceID"`
+}
+
+// parsePropertySet returns the state variables of a NOTIFY body, with the
+// variables from LastChange merged in. Per-channel RenderingControl
+// variables are only kept for the Master channel.
+func parsePropertySet(body []byte) (map[string]string, error) {
+	set := propertySet{}
+	err := xml.Unmarshal(body, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, property := range set.Properties {
+		for _, value := range property.Values {
+			values[value.XMLName.Local] = value.Value
+		}
+	}
+
+	lastChange, ok := values["LastChange"]
+	if !ok || lastChange == "" {
+		return values, nil
+	}
+
+	event := lastChangeEvent{}
+	err = xml.Unmarshal([]byte(lastChange), &event)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LastChange: %w", err)
+	}
+
+	for _, value := range event.InstanceID.Values {
+		if value.Channel != "" && value.Channel != "Master" {
+			continue
+		}
+		values[value.XMLName.Local] = value.Val
+	}
+	return values, nil
+}
+
+func formatGenaTimeout(timeout time.Duration) string {
+	return fmt.Sprintf("Second-%d", int(timeout.Seconds()))
+}
+
+// parseGenaTimeout parses a TIMEOUT header, falling back to the UPnP
+// minimum of 30 minutes for "infinite" or missing values.
+func parseGenaTimeout(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimPrefix(header, "Second-"))
+	if err != nil || seconds <= 0 {
+		return genaTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
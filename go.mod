@@ -1,4 +1,4 @@
-module main
+module github.com/SKBotNL/YouSonos
 
 go 1.19
 
@@ -0,0 +1,104 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "context"
+
+type GetGroupVolumeRequest struct {
+	InstanceID int
+}
+
+type GetGroupVolumeResponse struct {
+	CurrentVolume int
+}
+
+type SetGroupVolumeRequest struct {
+	InstanceID    int
+	DesiredVolume int
+}
+
+type SetRelativeGroupVolumeRequest struct {
+	InstanceID int
+	Adjustment int
+}
+
+type SetRelativeGroupVolumeResponse struct {
+	NewVolume int
+}
+
+type SnapshotGroupVolumeRequest struct {
+	InstanceID int
+}
+
+type GetGroupMuteRequest struct {
+	InstanceID int
+}
+
+type GetGroupMuteResponse struct {
+	CurrentMute bool
+}
+
+type SetGroupMuteRequest struct {
+	InstanceID  int
+	DesiredMute int
+}
+
+// The GroupRenderingControl actions have to be sent to the group coordinator.
+
+func (c *SoapClient) GetGroupVolume(ctx context.Context) (int, error) {
+	resp := GetGroupVolumeResponse{}
+	err := c.Call(ctx, groupRenderingControlService, "GetGroupVolume", GetGroupVolumeRequest{}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.CurrentVolume, nil
+}
+
+func (c *SoapClient) SetGroupVolume(ctx context.Context, volume int) error {
+	req := SetGroupVolumeRequest{DesiredVolume: volume}
+	return c.Call(ctx, groupRenderingControlService, "SetGroupVolume", req, nil)
+}
+
+// SetRelativeGroupVolume changes the group volume by adjustment and returns
+// the new group volume.
+func (c *SoapClient) SetRelativeGroupVolume(ctx context.Context, adjustment int) (int, error) {
+	req := SetRelativeGroupVolumeRequest{Adjustment: adjustment}
+	resp := SetRelativeGroupVolumeResponse{}
+	err := c.Call(ctx, groupRenderingControlService, "SetRelativeGroupVolume", req, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.NewVolume, nil
+}
+
+// SnapshotGroupVolume stores the volume ratio between the members, which
+// group volume changes keep intact.
+func (c *SoapClient) SnapshotGroupVolume(ctx context.Context) error {
+	return c.Call(ctx, groupRenderingControlService, "SnapshotGroupVolume", SnapshotGroupVolumeRequest{}, nil)
+}
+
+func (c *SoapClient) GetGroupMute(ctx context.Context) (bool, error) {
+	resp := GetGroupMuteResponse{}
+	err := c.Call(ctx, groupRenderingControlService, "GetGroupMute", GetGroupMuteRequest{}, &resp)
+	if err != nil {
+		return false, err
+	}
+	return resp.CurrentMute, nil
+}
+
+func (c *SoapClient) SetGroupMute(ctx context.Context, mute bool) error {
+	req := SetGroupMuteRequest{DesiredMute: upnpBool(mute)}
+	return c.Call(ctx, groupRenderingControlService, "SetGroupMute", req, nil)
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const standaloneGroup = "Standalone"
+
+type groupedMember struct {
+	member ZoneMember
+	group  ZoneGroup
+}
+
+func groupedMembers() []groupedMember {
+	var members []groupedMember
+	for _, group := range topology.Groups() {
+		for _, member := range group.VisibleMembers() {
+			members = append(members, groupedMember{member: member, group: group})
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].member.ZoneName < members[j].member.ZoneName
+	})
+	return members
+}
+
+// makeGroupPanel lists every speaker with the group it is in, which can be
+// changed to join it to another group or to take it out of its group. The
+// returned function refreshes the list. Changes run in activity.
+func makeGroupPanel(w fyne.Window, activity *ActivityBar) (fyne.CanvasObject, func()) {
+	var list *widget.List
+
+	change := func(member ZoneMember, selected string) {
+		activity.Run(w, "Moving "+member.ZoneName+" to "+selected, func(ctx context.Context) error {
+			// The select shows the group it was in again when this fails
+			defer list.Refresh()
+
+			if selected == standaloneGroup {
+				return topology.Unjoin(ctx, member)
+			}
+			for _, group := range topology.Groups() {
+				if group.Name() != selected {
+					continue
+				}
+				coordinator, _ := group.CoordinatorMember()
+				return topology.Join(ctx, member, coordinator)
+			}
+			return nil
+		})
+	}
+
+	list = widget.NewList(
+		func() int {
+			return len(groupedMembers())
+		},
+		func() fyne.CanvasObject {
+			return container.NewBorder(nil, nil, nil, widget.NewSelect(nil, nil), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			members := groupedMembers()
+			if id >= len(members) {
+				return
+			}
+			entry := members[id]
+
+			row := item.(*fyne.Container)
+			label := row.Objects[0].(*widget.Label)
+			groupSelect := row.Objects[1].(*widget.Select)
+
+			label.SetText(entry.member.ZoneName)
+
+			current := standaloneGroup
+			if len(entry.group.VisibleMembers()) > 1 {
+				current = entry.group.Name()
+			}
+
+			options := []string{standaloneGroup}
+			for _, group := range topology.Groups() {
+				if group.Id != entry.group.Id || current != standaloneGroup {
+					options = append(options, group.Name())
+				}
+			}
+
+			// Don't let refreshing the row trigger a change
+			groupSelect.OnChanged = nil
+			groupSelect.Options = options
+			groupSelect.Selected = current
+			groupSelect.Refresh()
+			groupSelect.OnChanged = func(selected string) {
+				if selected != current {
+					change(entry.member, selected)
+				}
+			}
+		},
+	)
+
+	return list, list.Refresh
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultInvidiousInstances = []string{
+	"https://invidious.namazso.eu",
+	"https://yewtu.be",
+	"https://vid.puffyan.us",
+	"https://inv.riverside.rocks",
+}
+
+// An instance counts as unhealthy after this many consecutive failures, it
+// is still tried but only after every healthy instance failed as well.
+const maxInstanceFailures = 2
+
+type Instance struct {
+	Url       string
+	Latency   time.Duration
+	Failures  int
+	LastError string
+	LastCheck time.Time
+}
+
+func (i Instance) Healthy() bool {
+	return i.Failures < maxInstanceFailures
+}
+
+// score ranks instances, lower is better. Instances that haven't answered
+// yet are assumed to be slow rather than fast.
+func (i Instance) score() float64 {
+	latency := float64(i.Latency.Milliseconds())
+	if i.Latency == 0 {
+		latency = 1000
+	}
+	return latency * float64(1+i.Failures)
+}
+
+// InstancePool keeps track of the health of a list of Invidious instances
+// and retries requests against the next instance when one fails.
+type InstancePool struct {
+	mu        sync.Mutex
+	instances []*Instance
+	active    string
+	watchers  map[int]func()
+	nextId    int
+
+	Client *http.Client
+}
+
+var invidiousPool = newInstancePool(defaultInvidiousInstances)
+
+func newInstancePool(urls []string) *InstancePool {
+	p := &InstancePool{}
+	p.SetUrls(urls)
+	return p
+}
+
+// parseInstanceList splits a newline or comma separated list of instance URLs.
+func parseInstanceList(list string) []string {
+	var urls []string
+	for _, field := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == '\n' || r == ',' || r == ' '
+	}) {
+		url := strings.TrimSuffix(strings.TrimSpace(field), "/")
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// SetUrls replaces the instance list, keeping the statistics of instances
+// that were already known.
+func (p *InstancePool) SetUrls(urls []string) {
+	p.mu.Lock()
+
+	known := make(map[string]*Instance)
+	for _, instance := range p.instances {
+		known[instance.Url] = instance
+	}
+
+	p.instances = nil
+	for _, url := range urls {
+		instance, ok := known[url]
+		if !ok {
+			instance = &Instance{Url: url}
+		}
+		p.instances = append(p.instances, instance)
+	}
+	p.mu.Unlock()
+
+	p.changed()
+}
+
+// Watch calls fn whenever the list or the health of an instance changed.
+// The returned function stops watching.
+func (p *InstancePool) Watch(fn func()) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watchers == nil {
+		p.watchers = make(map[int]func())
+	}
+	id := p.nextId
+	p.nextId++
+	p.watchers[id] = fn
+
+	return func() {
+		p.mu.Lock()
+		delete(p.watchers, id)
+		p.mu.Unlock()
+	}
+}
+
+func (p *InstancePool) changed() {
+	p.mu.Lock()
+	watchers := make([]func(), 0, len(p.watchers))
+	for _, fn := range p.watchers {
+		watchers = append(watchers, fn)
+	}
+	p.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+}
+
+func (p *InstancePool) Instances() []Instance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instances := make([]Instance, len(p.instances))
+	for i, instance := range p.instances {
+		instances[i] = *instance
+	}
+	return instances
+}
+
+// Active returns the instance that served the last successful request, or
+// the best ranked one if there was none yet.
+func (p *InstancePool) Active() string {
+	p.mu.Lock()
+	active := p.active
+	p.mu.Unlock()
+
+	if active != "" {
+		return active
+	}
+
+	ranked := p.Ranked()
+	if len(ranked) == 0 {
+		return ""
+	}
+	return ranked[0]
+}
+
+// Ranked returns the instance URLs ordered from most to least preferable.
+func (p *InstancePool) Ranked() []string {
+	instances := p.Instances()
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		if instances[i].Healthy() != instances[j].Healthy() {
+			return instances[i].Healthy()
+		}
+		return instances[i].score() < instances[j].score()
+	})
+
+	urls := make([]string, len(instances))
+	for i, instance := range instances {
+		urls[i] = instance.Url
+	}
+	return urls
+}
+
+// Report records the outcome of a request made to the instance at url.
+func (p *InstancePool) Report(url string, latency time.Duration, err error) {
+	p.mu.Lock()
+
+	for _, instance := range p.instances {
+		if instance.Url != url {
+			continue
+		}
+
+		instance.LastCheck = time.Now()
+		if err != nil {
+			instance.Failures++
+			instance.LastError = err.Error()
+			break
+		}
+
+		instance.Failures = 0
+		instance.LastError = ""
+		if instance.Latency == 0 {
+			instance.Latency = latency
+		} else {
+			instance.Latency = (instance.Latency*7 + latency*3) / 10
+		}
+		break
+	}
+	p.mu.Unlock()
+
+	p.changed()
+}
+
+// Do calls fn with the base URL of every instance in ranked order until one
+// call succeeds. Failures of the instance itself count against its health,
+// errors the instance reported about the request (such as a private video)
+// don't. It stops without blaming the instance when ctx is done.
+func (p *InstancePool) Do(ctx context.Context, fn func(baseUrl string) error) error {
+	ranked := p.Ranked()
+	if len(ranked) == 0 {
+		return errors.New("no Invidious instances configured")
+	}
+
+	var lastErr error
+	var lastApiErr error
+	for _, url := range ranked {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		start := time.Now()
+		err := fn(url)
+		if err == nil {
+			p.Report(url, time.Since(start), nil)
+
+			p.mu.Lock()
+			p.active = url
+			p.mu.Unlock()
+			p.changed()
+			return nil
+		}
+
+		// The caller giving up says nothing about the instance
+		if ctx.Err() != nil {
+			return err
+		}
+
+		var apiErr *InvidiousError
+		if errors.As(err, &apiErr) {
+			lastApiErr = err
+		} else {
+			p.Report(url, 0, err)
+		}
+		lastErr = err
+	}
+
+	// What the API said about the request is more useful than a dead instance
+	if lastApiErr != nil {
+		return lastApiErr
+	}
+	return lastErr
+}
+
+// Probe checks every instance once by requesting its statistics.
+func (p *InstancePool) Probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, instance := range p.Instances() {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := p.probe(ctx, url)
+			p.Report(url, time.Since(start), err)
+		}(instance.Url)
+	}
+	wg.Wait()
+}
+
+func (p *InstancePool) probe(ctx context.Context, url string) error {
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Resolve)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"/api/v1/stats", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("User-Agent", "YouSonos")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	stats := struct {
+		Software struct {
+			Name string `json:"name"`
+		} `json:"software"`
+	}{}
+	return json.Unmarshal(bodyBytes, &stats)
+}
+
+// Run probes all instances now and then every interval.
+func (p *InstancePool) Run(interval time.Duration) {
+	p.Probe(context.Background())
+	for range time.Tick(interval) {
+		p.Probe(context.Background())
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type FormatStream struct {
+	Url        string `json:"url"`
+	Type       string `json:"type"`
+	Container  string `json:"container"`
+	Resolution string `json:"resolution"`
+}
+
+type AdaptiveFormat struct {
+	Url       string      `json:"url"`
+	Type      string      `json:"type"`
+	Container string      `json:"container"`
+	Bitrate   json.Number `json:"bitrate"`
+}
+
+type Invidious struct {
+	Title           string           `json:"title"`
+	FormatStreams   []FormatStream   `json:"formatStreams"`
+	AdaptiveFormats []AdaptiveFormat `json:"adaptiveFormats"`
+	LengthSeconds   int              `json:"lengthSeconds"`
+}
+
+// InvidiousError is an error message returned by the Invidious API itself,
+// such as for private or removed videos.
+type InvidiousError struct {
+	Message string
+}
+
+func (e *InvidiousError) Error() string {
+	return e.Message
+}
+
+// InvidiousResolver resolves videos through the API of an Invidious
+// instance. When Pool is set the request is retried against the other
+// instances of the pool, otherwise BaseUrl is used.
+type InvidiousResolver struct {
+	BaseUrl string
+	Pool    *InstancePool
+	Client  *http.Client
+}
+
+func (r *InvidiousResolver) Name() string {
+	return "Invidious"
+}
+
+func (r *InvidiousResolver) Resolve(ctx context.Context, id string) (Media, error) {
+	if r.Pool == nil {
+		return r.resolveFrom(ctx, r.BaseUrl, id)
+	}
+
+	media := Media{}
+	err := r.Pool.Do(ctx, func(baseUrl string) error {
+		var err error
+		media, err = r.resolveFrom(ctx, baseUrl, id)
+		return err
+	})
+	return media, err
+}
+
+func (r *InvidiousResolver) resolveFrom(ctx context.Context, baseUrl string, id string) (Media, error) {
+	res := Invidious{}
+	err := invidiousGet(ctx, r.Client, baseUrl, "/api/v1/videos/"+id, &res)
+	if err != nil {
+		return Media{}, err
+	}
+
+	media := Media{
+		Id:            id,
+		Title:         res.Title,
+		LengthSeconds: res.LengthSeconds,
+		Thumbnail:     fmt.Sprintf("https://i.ytimg.com/vi/%s/maxresdefault.jpg", id),
+	}
+
+	for _, format := range res.AdaptiveFormats {
+		mimeType, codec := splitMimeType(format.Type)
+		if !strings.HasPrefix(mimeType, "audio/") {
+			continue
+		}
+
+		bitrate, _ := format.Bitrate.Int64()
+		media.Streams = append(media.Streams, StreamCandidate{
+			Url:       proxyUrl(baseUrl, format.Url),
+			MimeType:  mimeType,
+			Container: format.Container,
+			Codec:     codec,
+			Bitrate:   int(bitrate),
+			AudioOnly: true,
+		})
+	}
+
+	for _, formatStream := range res.FormatStreams {
+		mimeType, codec := splitMimeType(formatStream.Type)
+		media.Streams = append(media.Streams, StreamCandidate{
+			Url:        proxyUrl(baseUrl, formatStream.Url),
+			MimeType:   mimeType,
+			Container:  formatStream.Container,
+			Codec:      codec,
+			Resolution: formatStream.Resolution,
+		})
+	}
+
+	return media, nil
+}
+
+// invidiousGet requests path from the instance at baseUrl and decodes the
+// JSON response into v.
+func invidiousGet(ctx context.Context, client *http.Client, baseUrl string, path string, v interface{}) error {
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Resolve)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("User-Agent", "YouSonos")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	apiErr := struct {
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(bodyBytes, &apiErr) == nil && apiErr.Error != "" && resp.StatusCode != http.StatusTooManyRequests {
+		return &InvidiousError{Message: apiErr.Error}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invidious: %s", resp.Status)
+	}
+
+	return json.Unmarshal(bodyBytes, v)
+}
+
+// proxyUrl rewrites a googlevideo URL so the stream is proxied through the
+// instance at baseUrl instead of being fetched from YouTube directly.
+func proxyUrl(baseUrl string, stream string) string {
+	uLink, err := url.Parse(stream)
+	if err != nil || uLink.Host == "" {
+		return stream
+	}
+
+	return baseUrl + strings.TrimPrefix(stream, uLink.Scheme+"://"+uLink.Host)
+}
@@ -0,0 +1,353 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// The fake runs on a random port, golden files use this instead.
+const goldenInstanceUrl = "https://invidious.example"
+
+type resolverGolden struct {
+	Media  *Media           `json:"media,omitempty"`
+	Stream *StreamCandidate `json:"stream,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// encodeGolden encodes v the way golden files are stored, indented and with
+// the stream URLs kept readable.
+func encodeGolden(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	got := &bytes.Buffer{}
+	encoder := json.NewEncoder(got)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got.Bytes()
+}
+
+// checkGolden compares got with testdata/golden/name.json.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("result differs from %s, run the tests with -update after checking the change\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// useSettings changes the settings with update until the test ends.
+func useSettings(t *testing.T, update func(s *Settings)) {
+	previous := currentSettings()
+	t.Cleanup(func() {
+		updateSettings(func(s *Settings) {
+			*s = previous
+		})
+	})
+	updateSettings(update)
+}
+
+// useInvidious resolves with instance and the given stream settings until
+// the test ends.
+func useInvidious(t *testing.T, instance *fakeInvidious, maxBitrate int, transcode string) {
+	useSettings(t, func(s *Settings) {
+		s.Resolver = &InvidiousResolver{BaseUrl: instance.URL()}
+		s.Policy = StreamPolicy{MaxBitrate: maxBitrate}
+		s.Transcode = findTranscodeFormat(transcode)
+	})
+}
+
+func TestGetYtData(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+
+	tests := []struct {
+		name       string
+		url        string
+		maxBitrate int
+		transcode  string
+		// apiError is set when Invidious itself refused the video
+		apiError bool
+	}{
+		{name: "audio-only", url: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{name: "max-bitrate", url: "https://youtu.be/dQw4w9WgXcQ", maxBitrate: 64000},
+		{name: "muxed-fallback", url: "https://www.youtube.com/watch?v=jNQXAC9IVRw&t=5"},
+		{name: "incompatible-formats", url: "https://m.youtube.com/watch?v=kJQP7kiw5Fk"},
+		{name: "incompatible-formats-transcoded", url: "https://m.youtube.com/watch?v=kJQP7kiw5Fk", transcode: "FLAC"},
+		{name: "live-stream", url: "https://www.youtube.com/embed/jfKfPfyJRdk"},
+		{name: "age-restricted", url: "https://www.youtube.com/watch?v=HluANRwPyNo", apiError: true},
+		{name: "private", url: "youtube.com/watch?v=Wch3gJG2GJ4", apiError: true},
+		{name: "unavailable", url: "https://youtu.be/aaaaaaaaaaa", apiError: true},
+		{name: "not-youtube", url: "https://vimeo.com/76979871"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useInvidious(t, instance, test.maxBitrate, test.transcode)
+
+			media, stream, err := getYtData(ctx, test.url, currentSettings())
+
+			var apiErr *InvidiousError
+			if errors.As(err, &apiErr) != test.apiError {
+				t.Errorf("expected an Invidious error: %t, got %v", test.apiError, err)
+			}
+
+			result := resolverGolden{}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Media = &media
+				result.Stream = &stream
+			}
+
+			got := encodeGolden(t, result)
+			checkGolden(t, "invidious/"+test.name, bytes.ReplaceAll(got, []byte(instance.URL()), []byte(goldenInstanceUrl)))
+		})
+	}
+}
+
+func TestVideoUrl(t *testing.T) {
+	tests := []struct {
+		url string
+		id  string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"http://youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?feature=share&v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI&index=2", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?t=42", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/v/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI", ""},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ<script>", ""},
+		{"https://vimeo.com/76979871", ""},
+		{"dQw4w9WgXcQ", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		id := ""
+		if match := videoUrlRegexp.FindStringSubmatch(test.url); match != nil {
+			id = match[1]
+		}
+		if id != test.id {
+			t.Errorf("%q: expected %q, got %q", test.url, test.id, id)
+		}
+	}
+}
+
+func TestProxyUrl(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+	}{
+		{
+			name:   "googlevideo",
+			stream: "https://rr4---sn-5hne6nzk.googlevideo.com/videoplayback?expire=1674003615&itag=140&host=rr4---sn-5hne6nzk.googlevideo.com",
+			want:   goldenInstanceUrl + "/videoplayback?expire=1674003615&itag=140&host=rr4---sn-5hne6nzk.googlevideo.com",
+		},
+		{
+			name:   "plain http",
+			stream: "http://rr4---sn-5hne6nzk.googlevideo.com/videoplayback?itag=140",
+			want:   goldenInstanceUrl + "/videoplayback?itag=140",
+		},
+		{
+			name:   "escaped query",
+			stream: "https://rr4---sn-5hne6nzk.googlevideo.com/videoplayback?mime=audio%2Fmp4&sparams=expire%2Cei",
+			want:   goldenInstanceUrl + "/videoplayback?mime=audio%2Fmp4&sparams=expire%2Cei",
+		},
+		{
+			name:   "already local",
+			stream: "/latest_version?id=dQw4w9WgXcQ&itag=140&local=true",
+			want:   "/latest_version?id=dQw4w9WgXcQ&itag=140&local=true",
+		},
+		{
+			name:   "invalid",
+			stream: "https://%zz/videoplayback",
+			want:   "https://%zz/videoplayback",
+		},
+	}
+
+	for _, test := range tests {
+		got := proxyUrl(goldenInstanceUrl, test.stream)
+		if got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestInvidiousGetErrors(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+
+	tests := []struct {
+		name     string
+		status   int
+		path     string
+		want     string
+		apiError bool
+	}{
+		{name: "private", path: "/api/v1/videos/Wch3gJG2GJ4", want: "This video is private", apiError: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, path: "/api/v1/videos/dQw4w9WgXcQ", want: "invidious: 429 Too Many Requests"},
+		{name: "broken instance", status: http.StatusBadGateway, path: "/api/v1/videos/dQw4w9WgXcQ", want: "invidious: 502 Bad Gateway"},
+		{name: "not the API", path: "/watch", want: "invidious: 404 Not Found"},
+	}
+
+	for _, test := range tests {
+		instance.mu.Lock()
+		instance.Status = test.status
+		instance.mu.Unlock()
+
+		err := invidiousGet(ctx, nil, instance.URL(), test.path, &Invidious{})
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, err)
+			continue
+		}
+
+		var apiErr *InvidiousError
+		if errors.As(err, &apiErr) != test.apiError {
+			t.Errorf("%s: expected an Invidious error: %t, got %T", test.name, test.apiError, err)
+		}
+	}
+}
+
+func TestInstancePoolFailover(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// Neither has been checked yet, so the dead one is tried first
+	pool := newInstancePool([]string{dead.URL, instance.URL()})
+	resolver := &InvidiousResolver{Pool: pool}
+
+	media, err := resolver.Resolve(ctx, "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Title != "Rick Astley - Never Gonna Give You Up (Official Music Video)" {
+		t.Fatalf("unexpected title %q", media.Title)
+	}
+	if !strings.HasPrefix(media.Streams[0].Url, instance.URL()+"/videoplayback?") {
+		t.Fatalf("expected streams to be proxied through the instance that answered, got %s", media.Streams[0].Url)
+	}
+	if pool.Active() != instance.URL() {
+		t.Fatalf("expected %s to be active, got %s", instance.URL(), pool.Active())
+	}
+
+	// A private video is not the fault of the instance
+	_, err = resolver.Resolve(ctx, "Wch3gJG2GJ4")
+	var apiErr *InvidiousError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an Invidious error, got %v", err)
+	}
+
+	pool.Probe(ctx)
+
+	for _, checked := range pool.Instances() {
+		switch checked.Url {
+		case dead.URL:
+			if checked.Healthy() {
+				t.Errorf("expected %s to be unhealthy after failing twice", checked.Url)
+			}
+		case instance.URL():
+			if !checked.Healthy() || checked.Failures != 0 {
+				t.Errorf("expected %s to be healthy, got %d failures", checked.Url, checked.Failures)
+			}
+		}
+	}
+
+	if ranked := pool.Ranked(); ranked[0] != instance.URL() {
+		t.Errorf("expected %s to be ranked first, got %v", instance.URL(), ranked)
+	}
+}
+
+// TestInstancePoolWatch is meant for -race, the settings window watches the
+// pool while resolving reports to it.
+func TestInstancePoolWatch(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+	pool := newInstancePool([]string{instance.URL()})
+
+	var mu sync.Mutex
+	notified := 0
+	stop := pool.Watch(func() {
+		mu.Lock()
+		notified++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pool.Probe(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			pool.Watch(func() {})()
+		}()
+	}
+	wg.Wait()
+
+	stop()
+	pool.Probe(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if notified != 4 {
+		t.Fatalf("expected a notification for every probe while watching, got %d", notified)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const redirectorPort = 9372
+
+// localAddressFor returns the address the speaker at speakerHost is told to
+// fetch streams from, the advertised address if set and the detected one
+// otherwise.
+func localAddressFor(speakerHost string) (string, error) {
+	if address := currentSettings().AdvertiseAddress; address != "" {
+		return address, nil
+	}
+	return detectLocalAddress(speakerHost)
+}
+
+// detectLocalAddress returns the address of the interface the speaker at
+// speakerHost is reached through, which is the address the speaker can
+// reach us on as well.
+func detectLocalAddress(speakerHost string) (string, error) {
+	u, err := url.Parse(speakerHost)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid speaker address %q", speakerHost)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "1400")
+	}
+
+	// Connecting a UDP socket only looks up the route, nothing is sent
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the local address for %s: %w", u.Hostname(), err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// streamUrl returns the URL the speaker fetches the stream with token from.
+func streamUrl(localIp string, token string, extension string) string {
+	host := net.JoinHostPort(localIp, strconv.Itoa(redirectorPort))
+	return fmt.Sprintf("http://%s/%s.%s", host, token, extension)
+}
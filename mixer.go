@@ -0,0 +1,280 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MemberVolume is the volume of a single speaker in a group.
+type MemberVolume struct {
+	Member ZoneMember
+	Volume int
+	Muted  bool
+}
+
+// GroupMixer holds the group volume of the selected speaker's group and the
+// volumes of its members. It is filled by Refresh and kept up to date by
+// the RenderingControl and GroupRenderingControl events.
+type GroupMixer struct {
+	mu          sync.Mutex
+	coordinator string
+	groupVolume int
+	groupMuted  bool
+	members     map[string]MemberVolume
+
+	watchers map[int]func()
+	nextId   int
+}
+
+var mixer = &GroupMixer{}
+
+// Watch calls fn after a volume changed. The returned function stops watching.
+func (m *GroupMixer) Watch(fn func()) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watchers == nil {
+		m.watchers = make(map[int]func())
+	}
+	id := m.nextId
+	m.nextId++
+	m.watchers[id] = fn
+
+	return func() {
+		m.mu.Lock()
+		delete(m.watchers, id)
+		m.mu.Unlock()
+	}
+}
+
+func (m *GroupMixer) changed() {
+	m.mu.Lock()
+	watchers := make([]func(), 0, len(m.watchers))
+	for _, fn := range m.watchers {
+		watchers = append(watchers, fn)
+	}
+	m.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+}
+
+// Refresh loads the members of the group device is in and all volumes.
+func (m *GroupMixer) Refresh(ctx context.Context, device Device) error {
+	var members []ZoneMember
+	group, ok := topology.GroupOf(device.UDN)
+	if ok {
+		members = group.VisibleMembers()
+	} else {
+		members = []ZoneMember{{ZoneName: device.Name, Location: device.Host}}
+	}
+
+	coordinator := newSoapClient(topology.Coordinator(device).Host)
+
+	groupVolume, err := coordinator.GetGroupVolume(ctx)
+	if err != nil {
+		return err
+	}
+	groupMuted, err := coordinator.GetGroupMute(ctx)
+	if err != nil {
+		return err
+	}
+
+	volumes := make(map[string]MemberVolume)
+	for _, member := range members {
+		client := newSoapClient(member.Host())
+
+		volume, err := client.GetVolume(ctx)
+		if err != nil {
+			return err
+		}
+		muted, err := client.GetMute(ctx)
+		if err != nil {
+			return err
+		}
+
+		volumes[member.Host()] = MemberVolume{Member: member, Volume: volume, Muted: muted}
+	}
+
+	// Group volume changes keep the ratio from the last snapshot
+	err = coordinator.SnapshotGroupVolume(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.coordinator = coordinator.Host
+	m.groupVolume = groupVolume
+	m.groupMuted = groupMuted
+	m.members = volumes
+	m.mu.Unlock()
+
+	m.changed()
+	return nil
+}
+
+// GroupVolume returns the volume and mute state of the whole group.
+func (m *GroupMixer) GroupVolume() (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.groupVolume, m.groupMuted
+}
+
+// Members returns the member volumes sorted by room name.
+func (m *GroupMixer) Members() []MemberVolume {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := make([]MemberVolume, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Member.ZoneName < members[j].Member.ZoneName
+	})
+	return members
+}
+
+func (m *GroupMixer) coordinatorClient() *SoapClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return newSoapClient(m.coordinator)
+}
+
+func (m *GroupMixer) SetGroupVolume(ctx context.Context, volume int) error {
+	err := m.coordinatorClient().SetGroupVolume(ctx, volume)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.groupVolume = volume
+	m.mu.Unlock()
+
+	m.changed()
+	return nil
+}
+
+// SetRelativeGroupVolume changes the group volume by adjustment.
+func (m *GroupMixer) SetRelativeGroupVolume(ctx context.Context, adjustment int) error {
+	volume, err := m.coordinatorClient().SetRelativeGroupVolume(ctx, adjustment)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.groupVolume = volume
+	m.mu.Unlock()
+
+	m.changed()
+	return nil
+}
+
+func (m *GroupMixer) SetGroupMute(ctx context.Context, mute bool) error {
+	err := m.coordinatorClient().SetGroupMute(ctx, mute)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.groupMuted = mute
+	m.mu.Unlock()
+
+	m.changed()
+	return nil
+}
+
+// SetVolume changes the volume of the member at host.
+func (m *GroupMixer) SetVolume(ctx context.Context, host string, volume int) error {
+	err := newSoapClient(host).SetVolume(ctx, volume)
+	if err != nil {
+		return err
+	}
+
+	// The ratio between the members changed, so take a new snapshot for
+	// the group volume to work from
+	err = m.coordinatorClient().SnapshotGroupVolume(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.update(host, func(member *MemberVolume) {
+		member.Volume = volume
+	})
+	return nil
+}
+
+// SetMute mutes or unmutes the member at host.
+func (m *GroupMixer) SetMute(ctx context.Context, host string, mute bool) error {
+	err := newSoapClient(host).SetMute(ctx, mute)
+	if err != nil {
+		return err
+	}
+
+	m.update(host, func(member *MemberVolume) {
+		member.Muted = mute
+	})
+	return nil
+}
+
+func (m *GroupMixer) update(host string, fn func(member *MemberVolume)) {
+	m.mu.Lock()
+	member, ok := m.members[host]
+	if ok {
+		fn(&member)
+		m.members[host] = member
+	}
+	m.mu.Unlock()
+
+	if ok {
+		m.changed()
+	}
+}
+
+// HandleEvent applies a RenderingControl or GroupRenderingControl event.
+func (m *GroupMixer) HandleEvent(sub Subscription, values map[string]string) {
+	switch sub.Service {
+	case renderingControlService:
+		m.update(sub.Host, func(member *MemberVolume) {
+			if volume, err := strconv.Atoi(values["Volume"]); err == nil {
+				member.Volume = volume
+			}
+			if muted, err := strconv.ParseBool(values["Mute"]); err == nil {
+				member.Muted = muted
+			}
+		})
+	case groupRenderingControlService:
+		m.mu.Lock()
+		if sub.Host != m.coordinator {
+			m.mu.Unlock()
+			return
+		}
+		if volume, err := strconv.Atoi(values["GroupVolume"]); err == nil {
+			m.groupVolume = volume
+		}
+		if muted, err := strconv.ParseBool(values["GroupMute"]); err == nil {
+			m.groupMuted = muted
+		}
+		m.mu.Unlock()
+
+		m.changed()
+	}
+}
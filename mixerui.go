@@ -0,0 +1,177 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type mixerRow struct {
+	label  *widget.Label
+	slider *widget.Slider
+	volume *widget.Label
+	mute   *widget.Check
+}
+
+func newMixerRow(name string, onVolume func(volume int), onMute func(mute bool)) *mixerRow {
+	row := &mixerRow{
+		label:  widget.NewLabel(name),
+		slider: widget.NewSlider(0, 100),
+		volume: widget.NewLabel("0%"),
+		mute:   widget.NewCheck("Mute", nil),
+	}
+	row.slider.OnChanged = func(value float64) {
+		onVolume(int(value))
+	}
+	row.mute.OnChanged = onMute
+	return row
+}
+
+func (row *mixerRow) object() fyne.CanvasObject {
+	return container.NewBorder(nil, nil, row.label, container.NewHBox(row.volume, row.mute), row.slider)
+}
+
+// set shows volume and mute without calling back into the speaker.
+func (row *mixerRow) set(volume int, muted bool) {
+	row.slider.Value = float64(volume)
+	row.slider.Refresh()
+	row.volume.SetText(fmt.Sprintf("%d%%", volume))
+
+	onMute := row.mute.OnChanged
+	row.mute.OnChanged = nil
+	row.mute.SetChecked(muted)
+	row.mute.OnChanged = onMute
+}
+
+// muteMessage describes muting or unmuting what.
+func muteMessage(mute bool, what string) string {
+	if mute {
+		return "Muting " + what
+	}
+	return "Unmuting " + what
+}
+
+// openMixer shows the group volume of the selected speaker's group with a
+// slider and mute toggle for every member.
+func openMixer(a fyne.App) {
+	w := a.NewWindow("Mixer")
+
+	if !controller.HasDevice() {
+		dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
+	}
+
+	// Requests still running when the window closes are cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	activity := newActivityBar()
+
+	showError := func(err error) {
+		showUnlessCancelled(err, w)
+	}
+
+	groupVolume := newSliderSender(activity, w, "Changing the group volume", mixer.SetGroupVolume)
+	groupRow := newMixerRow("Group", groupVolume.Send, func(mute bool) {
+		activity.Run(w, muteMessage(mute, "the group"), func(ctx context.Context) error {
+			return mixer.SetGroupMute(ctx, mute)
+		})
+	})
+
+	downButton := widget.NewButtonWithIcon("", theme.VolumeDownIcon(), func() {
+		activity.Run(w, "Turning the group down", func(ctx context.Context) error {
+			return mixer.SetRelativeGroupVolume(ctx, -5)
+		})
+	})
+	upButton := widget.NewButtonWithIcon("", theme.VolumeUpIcon(), func() {
+		activity.Run(w, "Turning the group up", func(ctx context.Context) error {
+			return mixer.SetRelativeGroupVolume(ctx, 5)
+		})
+	})
+
+	memberBox := container.NewVBox()
+	rows := make(map[string]*mixerRow)
+
+	refresh := func() {
+		volume, muted := mixer.GroupVolume()
+		groupRow.set(volume, muted)
+
+		members := mixer.Members()
+
+		rebuild := len(members) != len(rows)
+		for _, member := range members {
+			if _, ok := rows[member.Member.Host()]; !ok {
+				rebuild = true
+			}
+		}
+
+		if rebuild {
+			rows = make(map[string]*mixerRow)
+			memberBox.Objects = nil
+			for _, member := range members {
+				host := member.Member.Host()
+				name := member.Member.ZoneName
+				// Every step sets the volume and then the group's ratio
+				volume := newSliderSender(activity, w, "Changing the volume of "+name, func(ctx context.Context, volume int) error {
+					return mixer.SetVolume(ctx, host, volume)
+				})
+				row := newMixerRow(name, volume.Send, func(mute bool) {
+					activity.Run(w, muteMessage(mute, name), func(ctx context.Context) error {
+						return mixer.SetMute(ctx, host, mute)
+					})
+				})
+				rows[host] = row
+				memberBox.Add(row.object())
+			}
+			memberBox.Refresh()
+		}
+
+		for _, member := range members {
+			rows[member.Member.Host()].set(member.Volume, member.Muted)
+		}
+	}
+
+	load := func() {
+		device := controller.Device()
+		if (Device{}) == device {
+			return
+		}
+		go func() {
+			showError(mixer.Refresh(ctx, device))
+		}()
+	}
+
+	stopWatchingMixer := mixer.Watch(refresh)
+	stopWatchingGroups := topology.Watch(load)
+	w.SetOnClosed(func() {
+		cancel()
+		activity.Cancel()
+		stopWatchingMixer()
+		stopWatchingGroups()
+	})
+
+	refresh()
+	load()
+
+	groupBox := container.NewBorder(nil, nil, nil, container.NewHBox(downButton, upButton), groupRow.object())
+	w.SetContent(container.NewVBox(groupBox, widget.NewSeparator(), memberBox, activity.Object()))
+
+	w.Resize(fyne.NewSize(500, 200))
+	w.Show()
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type PipedStream struct {
+	Url       string `json:"url"`
+	Format    string `json:"format"`
+	Quality   string `json:"quality"`
+	MimeType  string `json:"mimeType"`
+	Codec     string `json:"codec"`
+	Bitrate   int    `json:"bitrate"`
+	VideoOnly bool   `json:"videoOnly"`
+}
+
+type Piped struct {
+	Title        string        `json:"title"`
+	Duration     int           `json:"duration"`
+	ThumbnailUrl string        `json:"thumbnailUrl"`
+	AudioStreams []PipedStream `json:"audioStreams"`
+	VideoStreams []PipedStream `json:"videoStreams"`
+	Error        string        `json:"error"`
+	Message      string        `json:"message"`
+}
+
+// Piped reports containers by format name instead of file extension.
+var pipedContainers = map[string]string{
+	"M4A":        "m4a",
+	"MPEG_4":     "mp4",
+	"WEBMA":      "webm",
+	"WEBMA_OPUS": "webm",
+	"WEBM":       "webm",
+	"v3GPP":      "3gp",
+}
+
+// PipedResolver resolves videos through the API of a Piped instance.
+type PipedResolver struct {
+	ApiUrl string
+	Client *http.Client
+}
+
+func (r *PipedResolver) Name() string {
+	return "Piped"
+}
+
+func (r *PipedResolver) Resolve(ctx context.Context, id string) (Media, error) {
+	pipedUrl := fmt.Sprintf("%s/streams/%s", strings.TrimSuffix(r.ApiUrl, "/"), id)
+
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Resolve)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pipedUrl, nil)
+	if err != nil {
+		return Media{}, err
+	}
+
+	req.Header.Add("User-Agent", "YouSonos")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Media{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Media{}, err
+	}
+
+	res := Piped{}
+	err = json.Unmarshal(bodyBytes, &res)
+	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return Media{}, fmt.Errorf("piped: %s", resp.Status)
+		}
+		return Media{}, err
+	}
+
+	if res.Message != "" {
+		return Media{}, errors.New(res.Message)
+	}
+	if res.Error != "" {
+		return Media{}, errors.New(res.Error)
+	}
+
+	media := Media{
+		Id:            id,
+		Title:         res.Title,
+		LengthSeconds: res.Duration,
+		Thumbnail:     res.ThumbnailUrl,
+	}
+
+	for _, stream := range res.AudioStreams {
+		media.Streams = append(media.Streams, StreamCandidate{
+			Url:       stream.Url,
+			MimeType:  stream.MimeType,
+			Container: pipedContainers[stream.Format],
+			Codec:     stream.Codec,
+			Bitrate:   stream.Bitrate,
+			AudioOnly: true,
+		})
+	}
+
+	for _, stream := range res.VideoStreams {
+		if stream.VideoOnly {
+			continue
+		}
+		media.Streams = append(media.Streams, StreamCandidate{
+			Url:        stream.Url,
+			MimeType:   stream.MimeType,
+			Container:  pipedContainers[stream.Format],
+			Codec:      stream.Codec,
+			Bitrate:    stream.Bitrate,
+			Resolution: stream.Quality,
+		})
+	}
+
+	return media, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakePiped serves the responses in testdata/piped/streams. Like the real
+// thing it answers a video it can't play with a JSON error and status 500,
+// and status overrides that for every request when set.
+func newFakePiped(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/streams/")
+		if id == r.URL.Path || strings.ContainsAny(id, "/.") {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := os.ReadFile(filepath.Join("testdata", "piped", "streams", id+".json"))
+		if os.IsNotExist(err) {
+			body = []byte(`{"error":"com.github.kiulian.downloader.YoutubeException","message":"Video unavailable"}`)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), `"error"`) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPipedResolver(t *testing.T) {
+	ctx := context.Background()
+	server := newFakePiped(t, 0)
+	// A trailing slash as typed in the settings
+	resolver := &PipedResolver{ApiUrl: server.URL + "/"}
+
+	media, err := resolver.Resolve(ctx, "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Video-only streams are left out, the muxed one stays as a fallback
+	checkGolden(t, "piped/dQw4w9WgXcQ", encodeGolden(t, media))
+}
+
+func TestPipedResolverErrors(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		status int
+		id     string
+		want   string
+	}{
+		{"private", 0, "Wch3gJG2GJ4", "Video unavailable"},
+		{"unavailable", 0, "aaaaaaaaaaa", "Video unavailable"},
+		{"down", http.StatusBadGateway, "dQw4w9WgXcQ", "piped: 502 Bad Gateway"},
+	}
+
+	for _, test := range tests {
+		server := newFakePiped(t, test.status)
+		resolver := &PipedResolver{ApiUrl: server.URL}
+
+		_, err := resolver.Resolve(ctx, test.id)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, err)
+		}
+	}
+}
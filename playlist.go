@@ -0,0 +1,110 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type PlaylistVideo struct {
+	Title         string `json:"title"`
+	VideoId       string `json:"videoId"`
+	LengthSeconds int    `json:"lengthSeconds"`
+	Index         int    `json:"index"`
+}
+
+type InvidiousPlaylist struct {
+	Title      string          `json:"title"`
+	VideoCount int             `json:"videoCount"`
+	Videos     []PlaylistVideo `json:"videos"`
+}
+
+var playlistRegexp = regexp.MustCompile(`^(?:https?:)?(?:\/\/)?(?:www\.|m\.|music\.)?youtube\.com\/(?:playlist|watch)\?(?:.*&)?list=([a-zA-Z0-9\_-]+)`)
+
+// getPlaylistId returns the playlist ID of a YouTube playlist URL. Watch URLs
+// that carry a list= parameter count as playlists too, except for the
+// automatically generated mixes (RD...) which Invidious can't list.
+func getPlaylistId(ytUrl string) (string, bool) {
+	match := playlistRegexp.FindStringSubmatch(ytUrl)
+	if match == nil {
+		return "", false
+	}
+
+	id := match[1]
+	if strings.HasPrefix(id, "RD") {
+		return "", false
+	}
+
+	return id, true
+}
+
+// getPlaylist fetches all videos of a playlist page by page. progress is
+// called after every page with the number of videos fetched so far and the
+// total reported by Invidious.
+func getPlaylist(ctx context.Context, playlistId string, progress func(fetched int, total int)) (string, []PlaylistVideo, error) {
+	var videos []PlaylistVideo
+	seen := make(map[int]bool)
+	title := ""
+
+	for page := 1; ; page++ {
+		playlist, err := getPlaylistPage(ctx, playlistId, page)
+		if err != nil {
+			return "", nil, err
+		}
+		title = playlist.Title
+
+		added := 0
+		for _, video := range playlist.Videos {
+			if seen[video.Index] {
+				continue
+			}
+			seen[video.Index] = true
+			videos = append(videos, video)
+			added++
+		}
+
+		if progress != nil {
+			progress(len(videos), playlist.VideoCount)
+		}
+
+		// Some instances keep returning the last page instead of an empty one
+		if added == 0 || len(videos) >= playlist.VideoCount {
+			break
+		}
+	}
+
+	if len(videos) == 0 {
+		return "", nil, errors.New("playlist is empty or private")
+	}
+
+	return title, videos, nil
+}
+
+func getPlaylistPage(ctx context.Context, playlistId string, page int) (InvidiousPlaylist, error) {
+	res := InvidiousPlaylist{}
+	err := invidiousPool.Do(ctx, func(baseUrl string) error {
+		res = InvidiousPlaylist{}
+		return invidiousGet(ctx, nil, baseUrl, fmt.Sprintf("/api/v1/playlists/%s?page=%d", playlistId, page), &res)
+	})
+	if err != nil {
+		return InvidiousPlaylist{}, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Transport states reported by GetTransportInfo.
+const (
+	transportPlaying       = "PLAYING"
+	transportPaused        = "PAUSED_PLAYBACK"
+	transportStopped       = "STOPPED"
+	transportTransitioning = "TRANSITIONING"
+	transportNoMedia       = "NO_MEDIA_PRESENT"
+)
+
+// PlaybackStatus is a snapshot of what the speaker is doing.
+type PlaybackStatus struct {
+	State    string
+	Track    int
+	Uri      string
+	MetaData string
+	Position int
+	Duration int
+}
+
+func (s PlaybackStatus) Playing() bool {
+	return s.State == transportPlaying || s.State == transportTransitioning
+}
+
+// PositionTracker polls the selected speaker for its transport state and
+// position, so the UI follows the speaker instead of counting seconds
+// itself. That also picks up buffering and changes made from other
+// controllers.
+type PositionTracker struct {
+	mu     sync.Mutex
+	host   string
+	status PlaybackStatus
+	valid  bool
+
+	// OnUpdate is called after every successful poll.
+	OnUpdate func(status PlaybackStatus)
+	// OnTrackChanged is called when the speaker moved to another queue
+	// track, either by itself or because someone else skipped.
+	OnTrackChanged func(status PlaybackStatus)
+	// OnStopped is called when playback stopped without being paused,
+	// which is what happens after the last track in the queue ended.
+	OnStopped func(status PlaybackStatus)
+}
+
+// Status returns the last polled status.
+func (t *PositionTracker) Status() PlaybackStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// Poll asks host for its current state and fires the callbacks.
+func (t *PositionTracker) Poll(ctx context.Context, host string) error {
+	client := newSoapClient(host)
+
+	transport, err := client.GetTransportInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	position, err := client.GetPositionInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := PlaybackStatus{
+		State:    transport.CurrentTransportState,
+		Track:    position.Track,
+		Uri:      position.TrackURI,
+		MetaData: position.TrackMetaData,
+	}
+	// Sonos reports NOT_IMPLEMENTED for streams without a known position
+	status.Position, _ = parseHMS(position.RelTime)
+	status.Duration, _ = parseHMS(position.TrackDuration)
+
+	t.mu.Lock()
+	previous, valid := t.status, t.valid
+	if t.host != host {
+		valid = false
+	}
+	t.host = host
+	t.status = status
+	t.valid = true
+	t.mu.Unlock()
+
+	if t.OnUpdate != nil {
+		t.OnUpdate(status)
+	}
+
+	if !valid {
+		return nil
+	}
+
+	if status.Track != previous.Track && status.Track > 0 && t.OnTrackChanged != nil {
+		t.OnTrackChanged(status)
+	}
+
+	if previous.Playing() && status.State == transportStopped && t.OnStopped != nil {
+		t.OnStopped(status)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const appId = "nl.skbotnl.yousonos"
+
+// Preferences is the part of fyne.Preferences the settings are loaded
+// with. Creating the app needs a display, so the command line reads them
+// with filePreferences instead.
+type Preferences interface {
+	Bool(key string) bool
+	BoolWithFallback(key string, fallback bool) bool
+	Int(key string) int
+	String(key string) string
+	StringWithFallback(key, fallback string) string
+}
+
+// filePreferences are the preferences the app stored, read straight from
+// the file Fyne keeps them in.
+type filePreferences map[string]interface{}
+
+// preferencesPath returns where Fyne stores the preferences of the app
+// with the given ID on desktops.
+func preferencesPath(id string) (string, error) {
+	var root string
+	switch runtime.GOOS {
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(home, "Library", "Preferences")
+	case "windows":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		root = filepath.Join(home, "AppData", "Roaming")
+	default:
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		root = dir
+	}
+	return filepath.Join(root, "fyne", id, "preferences.json"), nil
+}
+
+// loadFilePreferences reads the preferences at path. Before the settings
+// were ever changed there is no file, which leaves every default.
+func loadFilePreferences(path string) (filePreferences, error) {
+	prefs := filePreferences{}
+
+	bodyBytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return prefs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(bodyBytes, &prefs)
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+func (p filePreferences) Bool(key string) bool {
+	return p.BoolWithFallback(key, false)
+}
+
+func (p filePreferences) BoolWithFallback(key string, fallback bool) bool {
+	value, ok := p[key]
+	if !ok {
+		return fallback
+	}
+	b, _ := value.(bool)
+	return b
+}
+
+// Int returns the value of key, which like every JSON number was decoded
+// as a float64.
+func (p filePreferences) Int(key string) int {
+	f, _ := p[key].(float64)
+	return int(f)
+}
+
+func (p filePreferences) String(key string) string {
+	return p.StringWithFallback(key, "")
+}
+
+func (p filePreferences) StringWithFallback(key, fallback string) string {
+	value, ok := p[key]
+	if !ok {
+		return fallback
+	}
+	s, _ := value.(string)
+	return s
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often a stream is reopened after the upstream connection dropped
+// without making any progress in between.
+const maxProxyRetries = 5
+
+var proxiedHeaders = []string{
+	"Accept-Ranges",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+}
+
+var proxyClient = &http.Client{}
+
+func fetchUpstream(ctx context.Context, method string, upstream string, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, upstream, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "YouSonos")
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	return proxyClient.Do(req)
+}
+
+// parseContentRange returns the first and last byte of a Content-Range
+// header such as "bytes 100-199/1000".
+func parseContentRange(contentRange string) (int64, int64, error) {
+	spec := strings.TrimPrefix(contentRange, "bytes ")
+	spec, _, _ = strings.Cut(spec, "/")
+
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, end, nil
+}
+
+// proxyStream streams upstream to the speaker itself instead of redirecting
+// it. Range requests are passed on, and when the upstream connection drops
+// mid-track the rest is requested again from where it stopped.
+func proxyStream(w http.ResponseWriter, r *http.Request, upstream string) {
+	resp, err := fetchUpstream(r.Context(), r.Method, upstream, r.Header.Get("Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+
+	for _, header := range proxiedHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if r.Method == http.MethodHead {
+		resp.Body.Close()
+		return
+	}
+
+	// offset is the next byte to send, end the last one or -1 for the end of the stream
+	offset, end := int64(0), int64(-1)
+	if resp.StatusCode == http.StatusPartialContent {
+		offset, end, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return
+		}
+	} else if resp.ContentLength >= 0 {
+		end = resp.ContentLength - 1
+	}
+
+	reader := &upstreamReader{
+		ctx:      r.Context(),
+		upstream: upstream,
+		body:     resp.Body,
+		offset:   offset,
+		end:      end,
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Stream ended early: %s", err)
+	}
+}
+
+// upstreamReader reads an upstream response body and transparently requests
+// the remaining bytes again when the connection drops. When the length of
+// the stream is known, a body that ends before the last byte counts as a
+// drop too, even when it ends cleanly. Without a length there is no telling
+// the two apart, so the end of the body is taken as the end of the stream.
+type upstreamReader struct {
+	ctx      context.Context
+	upstream string
+	body     io.ReadCloser
+	offset   int64
+	end      int64
+	retries  int
+}
+
+func (u *upstreamReader) Read(p []byte) (int, error) {
+	for {
+		n, err := u.body.Read(p)
+		u.offset += int64(n)
+		if n > 0 {
+			u.retries = 0
+		}
+
+		if err == nil || u.ctx.Err() != nil {
+			return n, err
+		}
+		if (u.end < 0 && err == io.EOF) || (u.end >= 0 && u.offset > u.end) {
+			return n, err
+		}
+		if n > 0 {
+			// Hand out what we got, the next read reconnects
+			return n, nil
+		}
+
+		u.body.Close()
+		next, err := reopenUpstream(u.ctx, u.upstream, u.offset, u.end, &u.retries)
+		if err != nil {
+			return 0, err
+		}
+		u.body = next
+	}
+}
+
+func (u *upstreamReader) Close() error {
+	return u.body.Close()
+}
+
+func reopenUpstream(ctx context.Context, upstream string, offset int64, end int64, retries *int) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if end >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, end)
+	}
+
+	for *retries < maxProxyRetries {
+		*retries++
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(*retries) * 500 * time.Millisecond):
+		}
+
+		resp, err := fetchUpstream(ctx, http.MethodGet, upstream, byteRange)
+		if err != nil {
+			continue
+		}
+
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			continue
+		}
+
+		start, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			resp.Body.Close()
+			continue
+		}
+
+		return resp.Body, nil
+	}
+
+	return nil, errors.New("upstream connection lost")
+}
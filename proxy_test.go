@@ -0,0 +1,181 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rangeUpstream serves data and honours Range requests the way the video
+// servers do. The first response is cut off after cutAfter bytes.
+type rangeUpstream struct {
+	data     []byte
+	cutAfter int
+	// chunked leaves out Content-Length, so the cut response ends cleanly
+	// instead of short of its length
+	chunked bool
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeUpstream(t *testing.T, size int, cutAfter int, chunked bool) (*rangeUpstream, *httptest.Server) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	upstream := &rangeUpstream{data: data, cutAfter: cutAfter, chunked: chunked}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	return upstream, server
+}
+
+func (u *rangeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	cut := len(u.ranges) == 0
+	u.ranges = append(u.ranges, r.Header.Get("Range"))
+	u.mu.Unlock()
+
+	start, end := 0, len(u.data)-1
+	status := http.StatusOK
+	if byteRange := r.Header.Get("Range"); byteRange != "" {
+		first, last, _ := strings.Cut(strings.TrimPrefix(byteRange, "bytes="), "-")
+		start, _ = strconv.Atoi(first)
+		if last != "" {
+			end, _ = strconv.Atoi(last)
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(u.data)))
+	}
+
+	body := u.data[start : end+1]
+	w.Header().Set("Content-Type", "audio/webm")
+	if !u.chunked {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	w.(http.Flusher).Flush()
+
+	if cut && u.cutAfter < len(body) {
+		w.Write(body[:u.cutAfter])
+		if u.chunked {
+			return
+		}
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func (u *rangeUpstream) Ranges() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.ranges...)
+}
+
+// getProxied requests upstream through proxyStream and returns the response
+// and everything that came through.
+func getProxied(t *testing.T, upstream string, byteRange string) (*http.Response, []byte) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyStream(w, r, upstream)
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected the whole stream, got %d bytes and %s", len(body), err)
+	}
+	return resp, body
+}
+
+func TestProxyStreamReconnect(t *testing.T) {
+	upstream, server := newRangeUpstream(t, 10000, 4000, false)
+
+	resp, body := getProxied(t, server.URL, "")
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != 10000 {
+		t.Errorf("expected 200 with the upstream length, got %s with %d", resp.Status, resp.ContentLength)
+	}
+	if !bytes.Equal(body, upstream.data) {
+		t.Errorf("expected the stream to be resumed seamlessly, got %d bytes", len(body))
+	}
+
+	ranges := upstream.Ranges()
+	if len(ranges) != 2 || ranges[1] != "bytes=4000-9999" {
+		t.Errorf("expected the rest to be requested once, got %q", ranges)
+	}
+}
+
+func TestProxyStreamRange(t *testing.T) {
+	upstream, server := newRangeUpstream(t, 10000, 50, false)
+
+	resp, body := getProxied(t, server.URL, "bytes=100-299")
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != "bytes 100-299/10000" {
+		t.Errorf("expected 206 for bytes 100-299, got %s for %s", resp.Status, resp.Header.Get("Content-Range"))
+	}
+	if !bytes.Equal(body, upstream.data[100:300]) {
+		t.Errorf("expected the requested range, got %d bytes", len(body))
+	}
+
+	ranges := upstream.Ranges()
+	if len(ranges) != 2 || ranges[0] != "bytes=100-299" || ranges[1] != "bytes=150-299" {
+		t.Errorf("expected the rest of the range to be requested, got %q", ranges)
+	}
+}
+
+func TestProxyStreamCleanEnd(t *testing.T) {
+	// The length is known from Content-Range, so ending early is a drop
+	upstream, server := newRangeUpstream(t, 10000, 4000, true)
+
+	_, body := getProxied(t, server.URL, "bytes=0-")
+	if !bytes.Equal(body, upstream.data) {
+		t.Errorf("expected a short body of known length to be resumed, got %d bytes", len(body))
+	}
+
+	// Without a length the end of the body is the end of the stream
+	upstream, server = newRangeUpstream(t, 10000, 4000, true)
+
+	_, body = getProxied(t, server.URL, "")
+	if !bytes.Equal(body, upstream.data[:4000]) {
+		t.Errorf("expected the stream to end with the body, got %d bytes", len(body))
+	}
+	if ranges := upstream.Ranges(); len(ranges) != 1 {
+		t.Errorf("expected no reconnect without a length, got %q", ranges)
+	}
+}
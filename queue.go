@@ -0,0 +1,370 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Queue mirrors the play queue of the selected speaker. Sonos only reports
+// the queue through ContentDirectory browsing, so YouSonos takes ownership
+// of the speaker queue instead: the first track added in a session clears
+// whatever was queued before, and from then on every change goes through
+// here so the local copy and the speaker stay in step.
+type Queue struct {
+	// changing makes changes reach the speaker one at a time and in order.
+	// It is held across the calls to the speaker, mu only while touching
+	// the local copy, so reading the queue never waits for the network.
+	changing sync.Mutex
+
+	mu      sync.Mutex
+	items   []Track
+	current int
+	owned   bool
+	// generation is bumped by Reset, so a change that was still on its way
+	// to the previous speaker isn't applied to the new one's queue
+	generation int
+
+	// transport returns the speaker holding the queue
+	transport func() Device
+	events    *EventBus
+}
+
+var errQueueReset = errors.New("the speaker changed while the queue was being changed")
+
+var errNoNextTrack = errors.New("there is no next track in the queue")
+var errNoPreviousTrack = errors.New("there is no previous track in the queue")
+
+func newQueue(transport func() Device, events *EventBus) *Queue {
+	return &Queue{current: -1, transport: transport, events: events}
+}
+
+func (q *Queue) client() *SoapClient {
+	return newSoapClient(q.transport().Host)
+}
+
+func (q *Queue) changed() {
+	q.events.Publish(QueueChanged{Current: q.Current(), Items: q.Items()})
+}
+
+// trackChanged is called after the speaker was told to play another track.
+func (q *Queue) trackChanged() {
+	track, ok := q.CurrentTrack()
+	if !ok {
+		q.events.Publish(TrackChanged{})
+		return
+	}
+	q.events.Publish(TrackChanged{Track: &track})
+}
+
+// snapshot returns the length of the queue and its generation, for a change
+// to check its arguments against before calling the speaker.
+func (q *Queue) snapshot() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.generation
+}
+
+// apply updates the local copy with fn once a change reached the speaker,
+// unless the queue was reset in the meantime.
+func (q *Queue) apply(generation int, fn func()) error {
+	q.mu.Lock()
+	if q.generation != generation {
+		q.mu.Unlock()
+		return errQueueReset
+	}
+	fn()
+	q.mu.Unlock()
+
+	q.changed()
+	return nil
+}
+
+// Reset forgets the local copy, e.g. after switching to another speaker.
+func (q *Queue) Reset() {
+	q.mu.Lock()
+	q.items = nil
+	q.current = -1
+	q.owned = false
+	q.generation++
+	q.mu.Unlock()
+
+	q.changed()
+	q.trackChanged()
+}
+
+func (q *Queue) Items() []Track {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]Track, len(q.items))
+	copy(items, q.items)
+	return items
+}
+
+// Current returns the index of the current track, or -1 if the queue is not playing.
+func (q *Queue) Current() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.current
+}
+
+func (q *Queue) CurrentTrack() (Track, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current < 0 || q.current >= len(q.items) {
+		return Track{}, false
+	}
+	return q.items[q.current], true
+}
+
+// Add appends track to the speaker queue and returns its index.
+func (q *Queue) Add(ctx context.Context, track Track) (int, error) {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	q.mu.Lock()
+	owned := q.owned
+	generation := q.generation
+	q.mu.Unlock()
+
+	client := q.client()
+
+	if !owned {
+		err := client.RemoveAllTracksFromQueue(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		err = q.apply(generation, func() {
+			q.items = nil
+			q.current = -1
+			q.owned = true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	number, err := client.AddURIToQueue(ctx, track.Uri, track.MetaData)
+	if err != nil {
+		return 0, err
+	}
+
+	index := 0
+	err = q.apply(generation, func() {
+		q.items = append(q.items, track)
+		index = len(q.items) - 1
+		if number != index+1 {
+			// Someone else changed the queue behind our back, start over next time.
+			q.owned = false
+		}
+	})
+	return index, err
+}
+
+func (q *Queue) Remove(ctx context.Context, index int) error {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	length, generation := q.snapshot()
+	if index < 0 || index >= length {
+		return errors.New("queue index out of range")
+	}
+
+	err := q.client().RemoveTrackFromQueue(ctx, index+1)
+	if err != nil {
+		return err
+	}
+
+	return q.apply(generation, func() {
+		q.items = append(q.items[:index], q.items[index+1:]...)
+		if index < q.current {
+			q.current--
+		} else if index == q.current {
+			q.current = -1
+		}
+	})
+}
+
+// Move moves the track at index from so that it ends up at index to.
+func (q *Queue) Move(ctx context.Context, from int, to int) error {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	length, generation := q.snapshot()
+	if to < 0 {
+		to = 0
+	}
+	if to >= length {
+		to = length - 1
+	}
+	if from < 0 || from >= length || from == to {
+		return nil
+	}
+
+	insertBefore := to + 1
+	if to > from {
+		insertBefore = to + 2
+	}
+
+	err := q.client().ReorderTracksInQueue(ctx, from+1, insertBefore)
+	if err != nil {
+		return err
+	}
+
+	return q.apply(generation, func() {
+		track := q.items[from]
+		q.items = append(q.items[:from], q.items[from+1:]...)
+		q.items = append(q.items[:to], append([]Track{track}, q.items[to:]...)...)
+
+		switch {
+		case q.current == from:
+			q.current = to
+		case from < q.current && to >= q.current:
+			q.current--
+		case from > q.current && to <= q.current:
+			q.current++
+		}
+	})
+}
+
+func (q *Queue) Clear(ctx context.Context) error {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	_, generation := q.snapshot()
+
+	err := q.client().RemoveAllTracksFromQueue(ctx)
+	if err != nil {
+		return err
+	}
+
+	return q.apply(generation, func() {
+		q.items = nil
+		q.current = -1
+		q.owned = true
+	})
+}
+
+// PlayIndex switches the speaker to its queue and starts playing the track at index.
+func (q *Queue) PlayIndex(ctx context.Context, index int) error {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	length, generation := q.snapshot()
+	if index < 0 || index >= length {
+		return errors.New("queue index out of range")
+	}
+
+	client := q.client()
+
+	udn := strings.TrimPrefix(q.transport().UDN, "uuid:")
+	err := client.SetAVTransportURI(ctx, "x-rincon-queue:"+udn+"#0", "")
+	if err != nil {
+		return err
+	}
+
+	err = client.SeekTrack(ctx, index+1)
+	if err != nil {
+		return err
+	}
+
+	err = client.Play(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = q.apply(generation, func() {
+		q.current = index
+	})
+	if err != nil {
+		return err
+	}
+	q.trackChanged()
+	return nil
+}
+
+func (q *Queue) Next(ctx context.Context) error {
+	return q.skip(ctx, 1)
+}
+
+func (q *Queue) Previous(ctx context.Context) error {
+	return q.skip(ctx, -1)
+}
+
+func (q *Queue) skip(ctx context.Context, delta int) error {
+	q.changing.Lock()
+	defer q.changing.Unlock()
+
+	q.mu.Lock()
+	index := q.current + delta
+	valid := q.current >= 0 && index >= 0 && index < len(q.items)
+	generation := q.generation
+	q.mu.Unlock()
+
+	if !valid {
+		if delta > 0 {
+			return errNoNextTrack
+		}
+		return errNoPreviousTrack
+	}
+
+	client := q.client()
+
+	var err error
+	if delta > 0 {
+		err = client.Next(ctx)
+	} else {
+		err = client.Previous(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = q.apply(generation, func() {
+		q.current = index
+	})
+	if err != nil {
+		return err
+	}
+	q.trackChanged()
+	return nil
+}
+
+// SyncTrack makes the track with the given 1-based queue number current
+// after the speaker moved to it on its own, e.g. because the previous one
+// ended. It returns false when number is not in the queue or already current.
+func (q *Queue) SyncTrack(number int) bool {
+	q.mu.Lock()
+
+	index := number - 1
+	if index < 0 || index >= len(q.items) || index == q.current {
+		q.mu.Unlock()
+		return false
+	}
+
+	q.current = index
+	q.mu.Unlock()
+
+	q.changed()
+	q.trackChanged()
+	return true
+}
@@ -0,0 +1,276 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var thumbnailCache = make(map[string]fyne.Resource)
+var thumbnailCacheMutex sync.Mutex
+
+// queueRow is a single queue entry that can be dragged up or down to reorder it.
+type queueRow struct {
+	widget.BaseWidget
+
+	index   int
+	dragged float32
+
+	icon   *widget.Icon
+	image  *canvas.Image
+	title  *widget.Label
+	remove *widget.Button
+
+	onMove   func(from int, to int)
+	onRemove func(index int)
+}
+
+func newQueueRow(onMove func(from int, to int), onRemove func(index int)) *queueRow {
+	row := &queueRow{
+		icon:     widget.NewIcon(nil),
+		image:    canvas.NewImageFromResource(resourceEmptythumbnailPng),
+		title:    widget.NewLabel(""),
+		onMove:   onMove,
+		onRemove: onRemove,
+	}
+	row.image.SetMinSize(fyne.NewSize(64, 36))
+	row.image.FillMode = canvas.ImageFillContain
+	row.title.Wrapping = fyne.TextTruncate
+	row.remove = widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		row.onRemove(row.index)
+	})
+	row.remove.Importance = widget.LowImportance
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+func (r *queueRow) CreateRenderer() fyne.WidgetRenderer {
+	left := container.NewHBox(r.icon, r.image)
+	return widget.NewSimpleRenderer(container.NewBorder(nil, nil, left, r.remove, r.title))
+}
+
+func (r *queueRow) Dragged(event *fyne.DragEvent) {
+	r.dragged += event.Dragged.DY
+}
+
+func (r *queueRow) DragEnd() {
+	rowHeight := r.Size().Height + theme.Padding()
+	rows := int(math.Round(float64(r.dragged / rowHeight)))
+	r.dragged = 0
+
+	if rows != 0 {
+		r.onMove(r.index, r.index+rows)
+	}
+}
+
+func (r *queueRow) update(index int, track Track, current bool, refresh func()) {
+	r.index = index
+	r.title.SetText(fmt.Sprintf("%d. %s", index+1, track.Title))
+	if current {
+		r.icon.SetResource(theme.MediaPlayIcon())
+	} else {
+		r.icon.SetResource(nil)
+	}
+
+	showCachedThumbnail(r.image, track.YtId, refresh)
+}
+
+// showCachedThumbnail shows the thumbnail of ytId in image if it has been
+// loaded, otherwise it is loaded in the background and refresh is called
+// once it is there.
+func showCachedThumbnail(image *canvas.Image, ytId string, refresh func()) {
+	thumbnailCacheMutex.Lock()
+	res, ok := thumbnailCache[ytId]
+	thumbnailCacheMutex.Unlock()
+	if ok {
+		image.Resource = res
+	} else {
+		image.Resource = resourceEmptythumbnailPng
+		go loadCachedThumbnail(ytId, refresh)
+	}
+	image.Refresh()
+}
+
+func loadCachedThumbnail(ytId string, refresh func()) {
+	thumbnailCacheMutex.Lock()
+	if _, ok := thumbnailCache[ytId]; ok {
+		thumbnailCacheMutex.Unlock()
+		return
+	}
+	// Claim the entry so concurrent rows don't fetch the same image
+	thumbnailCache[ytId] = resourceEmptythumbnailPng
+	thumbnailCacheMutex.Unlock()
+
+	thumbnailUrl := fmt.Sprintf("https://i.ytimg.com/vi/%s/mqdefault.jpg", ytId)
+	bodyBytes, err := loadThumbnail(context.Background(), thumbnailUrl)
+	if err != nil {
+		return
+	}
+	res := fyne.NewStaticResource(thumbnailUrl, bodyBytes)
+
+	thumbnailCacheMutex.Lock()
+	thumbnailCache[ytId] = res
+	thumbnailCacheMutex.Unlock()
+
+	refresh()
+}
+
+// makeQueuePanel builds the queue list. Changes to the queue run on activity.
+func makeQueuePanel(w fyne.Window, activity *ActivityBar) fyne.CanvasObject {
+	queue := controller.Queue()
+	var list *widget.List
+
+	refresh := func() {
+		list.Refresh()
+	}
+
+	move := func(from int, to int) {
+		activity.Run(w, "Moving a track", func(ctx context.Context) error {
+			return queue.Move(ctx, from, to)
+		})
+	}
+
+	remove := func(index int) {
+		activity.Run(w, "Removing a track", func(ctx context.Context) error {
+			return queue.Remove(ctx, index)
+		})
+	}
+
+	list = widget.NewList(
+		func() int {
+			return len(queue.Items())
+		},
+		func() fyne.CanvasObject {
+			return newQueueRow(move, remove)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			items := queue.Items()
+			if id >= len(items) {
+				return
+			}
+			item.(*queueRow).update(id, items[id], id == queue.Current(), refresh)
+		},
+	)
+
+	list.OnSelected = func(id widget.ListItemID) {
+		list.UnselectAll()
+
+		if !controller.HasDevice() {
+			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
+			return
+		}
+
+		activity.Run(w, "Playing track "+strconv.Itoa(id+1), func(ctx context.Context) error {
+			return queue.PlayIndex(ctx, id)
+		})
+	}
+
+	clearButton := widget.NewButton("Clear", func() {
+		if !controller.HasDevice() {
+			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
+			return
+		}
+
+		activity.Run(w, "Clearing the queue", queue.Clear)
+	})
+
+	controller.Events().Watch(func(event Event) {
+		if _, ok := event.(QueueChanged); ok {
+			refresh()
+		}
+	})
+
+	header := container.NewBorder(nil, nil, widget.NewLabel("Queue"), clearButton)
+	return container.NewBorder(header, nil, nil, nil, list)
+}
+
+// enqueuePlaylist adds every video of a playlist to the queue while showing
+// the progress in a dialog. When play is true the first track starts playing
+// as soon as it has been added, so large playlists don't have to be resolved
+// completely before something is heard.
+func enqueuePlaylist(w fyne.Window, playlistId string, play bool) {
+	status := widget.NewLabel("Loading playlist...")
+	progress := widget.NewProgressBar()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progressDialog := dialog.NewCustom("Importing playlist", "Cancel", container.NewVBox(status, progress), w)
+	progressDialog.SetOnClosed(cancel)
+	progressDialog.Resize(fyne.NewSize(400, 0))
+	progressDialog.Show()
+
+	go func() {
+		title, videos, err := getPlaylist(ctx, playlistId, func(fetched int, total int) {
+			status.SetText(fmt.Sprintf("Loading playlist... %d/%d videos", fetched, total))
+			if total > 0 {
+				progress.SetValue(float64(fetched) / float64(total))
+			}
+		})
+		if err != nil {
+			progressDialog.Hide()
+			showUnlessCancelled(err, w)
+			return
+		}
+
+		failed := 0
+		for i, video := range videos {
+			if ctx.Err() != nil {
+				return
+			}
+
+			status.SetText(fmt.Sprintf("Adding %s (%d/%d)", title, i+1, len(videos)))
+			progress.SetValue(float64(i) / float64(len(videos)))
+
+			track, err := controller.Resolve(ctx, "https://www.youtube.com/watch?v="+video.VideoId)
+			if err != nil {
+				failed++
+				continue
+			}
+
+			index, err := controller.Queue().Add(ctx, track)
+			if err != nil {
+				progressDialog.Hide()
+				showUnlessCancelled(err, w)
+				return
+			}
+
+			if play {
+				play = false
+				err = controller.Queue().PlayIndex(ctx, index)
+				if err != nil {
+					progressDialog.Hide()
+					showUnlessCancelled(err, w)
+					return
+				}
+			}
+		}
+
+		progressDialog.Hide()
+
+		if failed > 0 {
+			dialog.ShowInformation("Playlist imported", fmt.Sprintf("%d of %d videos could not be added", failed, len(videos)), w)
+		}
+	}()
+}
@@ -15,28 +15,122 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-var redirMap = make(map[int]string)
+var redirectorServer *http.Server
+var redirectorAddr string
+var redirectorMutex sync.Mutex
 
-func redirector() {
+func init() {
+	chi.RegisterMethod("NOTIFY")
+}
+
+// redirector (re)starts the HTTP server the speaker fetches streams from.
+// When BindRedirector is set it only listens on the address the selected
+// speaker reaches us on, so it has to be restarted when that changes. It is
+// left alone when it already listens on the right address.
+func redirector() error {
+	addr := fmt.Sprintf(":%d", redirectorPort)
+	device := controller.Device()
+	if currentSettings().BindRedirector && (Device{}) != device {
+		localIp, err := localAddressFor(device.Host)
+		if err != nil {
+			return err
+		}
+		addr = net.JoinHostPort(localIp, strconv.Itoa(redirectorPort))
+	}
+
+	redirectorMutex.Lock()
+	defer redirectorMutex.Unlock()
+
+	if redirectorServer != nil {
+		if addr == redirectorAddr {
+			return nil
+		}
+		redirectorServer.Close()
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	redirectorAddr = addr
+	redirectorServer = &http.Server{Handler: redirectorRouter()}
+	go redirectorServer.Serve(ln)
+
+	return nil
+}
+
+func redirectorRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/{id}.mp4", redirect)
-	http.ListenAndServe(":9372", r)
+	r.Head("/{id}.mp4", redirect)
+	for _, format := range transcodeFormats {
+		r.Get("/{id}."+format.Extension, redirect)
+		r.Head("/{id}."+format.Extension, redirect)
+	}
+	// The listing holds the tokens and upstream URLs of every stream
+	r.With(controlApi.authenticate).Get("/debug/streams", listStreams)
+	r.Method("NOTIFY", genaCallbackPath, genaSubscriber)
+	r.Mount("/api/v1", controlApi.Routes())
+	r.Get("/remote", http.RedirectHandler("/remote/", http.StatusMovedPermanently).ServeHTTP)
+	r.Get("/remote/emptythumbnail.png", emptyThumbnail)
+	r.Handle("/remote/*", remoteFiles())
+	return r
 }
 
 func redirect(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
+	entry, exists := streamRegistry.Lookup(chi.URLParam(r, "id"))
+	if !exists {
+		http.NotFound(w, r)
 		return
 	}
-	value, exists := redirMap[id]
-	if !exists {
+	if format := findTranscodeFormat(entry.Transcode); format != nil {
+		transcodeStream(w, r, entry.Upstream, format)
 		return
 	}
-	http.Redirect(w, r, value, http.StatusFound)
+	if currentSettings().ProxyStreams {
+		proxyStream(w, r, entry.Upstream)
+		return
+	}
+	http.Redirect(w, r, entry.Upstream, http.StatusFound)
+}
+
+type streamListing struct {
+	Token     string    `json:"token"`
+	Title     string    `json:"title"`
+	Upstream  string    `json:"upstream"`
+	Transcode string    `json:"transcode,omitempty"`
+	Expires   time.Time `json:"expires"`
+}
+
+func listStreams(w http.ResponseWriter, r *http.Request) {
+	listing := []streamListing{}
+	for token, entry := range streamRegistry.Entries() {
+		listing = append(listing, streamListing{
+			Token:     token,
+			Title:     entry.Title,
+			Upstream:  entry.Upstream,
+			Transcode: entry.Transcode,
+			Expires:   entry.Expires,
+		})
+	}
+
+	sort.Slice(listing, func(i, j int) bool {
+		return listing[i].Expires.After(listing[j].Expires)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
 }
@@ -0,0 +1,235 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type StreamEntry struct {
+	Upstream string `json:"upstream"`
+	// Transcode is the name of the format the stream is converted to, empty
+	// to pass the stream on as is.
+	Transcode string    `json:"transcode,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Expires   time.Time `json:"expires"`
+}
+
+// StreamRegistry hands out the tokens the speaker uses to fetch a stream
+// from the redirector. Entries expire ttl after they were last requested,
+// and when path is set they are written to disk so a restart doesn't break
+// the track that is still playing.
+type StreamRegistry struct {
+	mu      sync.Mutex
+	entries map[string]StreamEntry
+	ttl     time.Duration
+	path    string
+	// dirty is set when lifetimes were extended since the last save
+	dirty bool
+	// now is replaced by tests to make entries expire
+	now func() time.Time
+}
+
+// Stream URLs handed out by YouTube stop working after about six hours anyway.
+const streamTTL = 6 * time.Hour
+
+var streamRegistry = newStreamRegistry(streamTTL, "")
+
+func newStreamRegistry(ttl time.Duration, path string) *StreamRegistry {
+	return &StreamRegistry{
+		entries: make(map[string]StreamEntry),
+		ttl:     ttl,
+		path:    path,
+		now:     time.Now,
+	}
+}
+
+func defaultStreamRegistryPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "yousonos", "streams.json"), nil
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// SetPath changes where the registry is stored and loads the entries that
+// were stored there before. An empty path keeps the registry in memory only.
+func (r *StreamRegistry) SetPath(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.path = path
+	if path == "" {
+		return nil
+	}
+
+	bodyBytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	stored := make(map[string]StreamEntry)
+	err = json.Unmarshal(bodyBytes, &stored)
+	if err != nil {
+		return err
+	}
+
+	for token, entry := range stored {
+		if _, ok := r.entries[token]; !ok {
+			r.entries[token] = entry
+		}
+	}
+	r.prune()
+
+	return r.save()
+}
+
+func (r *StreamRegistry) Register(entry StreamEntry) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.Expires = r.now().Add(r.ttl)
+	r.entries[token] = entry
+
+	// The stream still works without the copy on disk
+	err = r.save()
+	if err != nil {
+		log.Printf("Could not store streams: %s", err)
+	}
+
+	return token, nil
+}
+
+// Lookup returns the entry of token and extends its lifetime. The speaker
+// requests a stream again for every seek, so the new lifetime is only
+// written to disk by the next Prune.
+func (r *StreamRegistry) Lookup(token string) (StreamEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[token]
+	if !ok || r.now().After(entry.Expires) {
+		return StreamEntry{}, false
+	}
+
+	entry.Expires = r.now().Add(r.ttl)
+	r.entries[token] = entry
+	r.dirty = true
+
+	return entry, true
+}
+
+// Entries returns the entries that haven't expired, leaving out the expired
+// ones Prune hasn't removed yet.
+func (r *StreamRegistry) Entries() map[string]StreamEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	entries := make(map[string]StreamEntry, len(r.entries))
+	for token, entry := range r.entries {
+		if now.After(entry.Expires) {
+			continue
+		}
+		entries[token] = entry
+	}
+	return entries
+}
+
+// Prune removes the expired entries and stores the lifetimes extended by
+// Lookup.
+func (r *StreamRegistry) Prune() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.prune() || r.dirty {
+		return r.save()
+	}
+	return nil
+}
+
+func (r *StreamRegistry) prune() bool {
+	pruned := false
+	now := r.now()
+	for token, entry := range r.entries {
+		if now.After(entry.Expires) {
+			delete(r.entries, token)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+func (r *StreamRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	bodyBytes, err := json.Marshal(r.entries)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	err = os.WriteFile(tmp, bodyBytes, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, r.path)
+	if err != nil {
+		return err
+	}
+
+	r.dirty = false
+	return nil
+}
+
+// Run prunes expired entries and stores extended lifetimes every interval.
+func (r *StreamRegistry) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.Prune()
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testStreamTTL = time.Hour
+
+// newTestRegistry returns a registry stored at path whose clock only moves
+// when the returned function is called.
+func newTestRegistry(t *testing.T, path string) (*StreamRegistry, func(d time.Duration)) {
+	now := time.Date(2023, 1, 14, 12, 0, 0, 0, time.UTC)
+	r := newStreamRegistry(testStreamTTL, "")
+	r.now = func() time.Time {
+		return now
+	}
+
+	if path != "" {
+		err := r.SetPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+// readStoredStreams returns what the registry wrote to path.
+func readStoredStreams(t *testing.T, path string) map[string]StreamEntry {
+	t.Helper()
+
+	bodyBytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]StreamEntry)
+	err = json.Unmarshal(bodyBytes, &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestStreamRegistryExpiry(t *testing.T) {
+	r, advance := newTestRegistry(t, "")
+
+	token, err := r.Register(StreamEntry{Upstream: "https://example.com/audio", Title: "Audio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every lookup starts the lifetime over, like the speaker seeking
+	for i := 0; i < 3; i++ {
+		advance(testStreamTTL - time.Minute)
+		entry, ok := r.Lookup(token)
+		if !ok || entry.Upstream != "https://example.com/audio" {
+			t.Fatalf("expected the entry to be alive after %d lookups, got %+v", i, entry)
+		}
+	}
+
+	advance(testStreamTTL + time.Second)
+	if _, ok := r.Lookup(token); ok {
+		t.Fatal("expected the entry to expire a TTL after the last lookup")
+	}
+	if entries := r.Entries(); len(entries) != 0 {
+		t.Fatalf("expected expired entries not to be listed before pruning, got %v", entries)
+	}
+
+	err = r.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.entries) != 0 {
+		t.Fatalf("expected the entry to be pruned, got %v", r.entries)
+	}
+
+	if _, ok := r.Lookup("unknown"); ok {
+		t.Fatal("expected an unknown token not to be found")
+	}
+}
+
+func TestStreamRegistryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yousonos", "streams.json")
+	r, advance := newTestRegistry(t, path)
+
+	token, err := r.Register(StreamEntry{Upstream: "https://example.com/audio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := readStoredStreams(t, path)[token]
+	if registered.Upstream != "https://example.com/audio" {
+		t.Fatalf("expected registering to store the entry, got %v", readStoredStreams(t, path))
+	}
+
+	// Lookups only extend the lifetime in memory until the next prune
+	advance(time.Minute)
+	entry, _ := r.Lookup(token)
+	if stored := readStoredStreams(t, path)[token]; !stored.Expires.Equal(registered.Expires) {
+		t.Fatalf("expected a lookup not to write the registry, stored %s", stored.Expires)
+	}
+
+	err = r.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := readStoredStreams(t, path)[token]; !stored.Expires.Equal(entry.Expires) {
+		t.Fatalf("expected pruning to store the extended lifetime %s, stored %s", entry.Expires, stored.Expires)
+	}
+
+	// A restart picks up the stored entries next to the ones registered
+	// before the path was set, without the expired ones
+	expiring, err := r.Register(StreamEntry{Upstream: "https://example.com/expiring"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	expired := r.entries[expiring]
+	expired.Expires = r.now().Add(-time.Second)
+	r.entries[expiring] = expired
+	r.save()
+	r.mu.Unlock()
+
+	restarted, advanceRestarted := newTestRegistry(t, "")
+	advanceRestarted(time.Minute)
+	own, err := restarted.Register(StreamEntry{Upstream: "https://example.com/own"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restarted.SetPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := restarted.Entries()
+	if len(entries) != 2 || entries[token].Upstream != "https://example.com/audio" || entries[own].Upstream != "https://example.com/own" {
+		t.Fatalf("expected the stored and the own entry, got %v", entries)
+	}
+	if _, ok := readStoredStreams(t, path)[expiring]; ok {
+		t.Fatal("expected loading to drop the expired entry from disk")
+	}
+}
+
+func TestStreamRegistryTokens(t *testing.T) {
+	r, _ := newTestRegistry(t, "")
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		token, err := r.Register(StreamEntry{Upstream: "https://example.com/audio"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(token) != 32 || seen[token] {
+			t.Fatalf("expected a new 128-bit hex token, got %q", token)
+		}
+		seen[token] = true
+	}
+}
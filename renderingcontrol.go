@@ -0,0 +1,158 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "context"
+
+type GetVolumeRequest struct {
+	InstanceID int
+	Channel    string
+}
+
+type GetVolumeResponse struct {
+	CurrentVolume int
+}
+
+type SetVolumeRequest struct {
+	InstanceID    int
+	Channel       string
+	DesiredVolume int
+}
+
+type GetMuteRequest struct {
+	InstanceID int
+	Channel    string
+}
+
+type GetMuteResponse struct {
+	CurrentMute bool
+}
+
+type SetMuteRequest struct {
+	InstanceID  int
+	Channel     string
+	DesiredMute int
+}
+
+type GetBassRequest struct {
+	InstanceID int
+}
+
+type GetBassResponse struct {
+	CurrentBass int
+}
+
+type SetBassRequest struct {
+	InstanceID  int
+	DesiredBass int
+}
+
+type GetTrebleRequest struct {
+	InstanceID int
+}
+
+type GetTrebleResponse struct {
+	CurrentTreble int
+}
+
+type SetTrebleRequest struct {
+	InstanceID    int
+	DesiredTreble int
+}
+
+type GetLoudnessRequest struct {
+	InstanceID int
+	Channel    string
+}
+
+type GetLoudnessResponse struct {
+	CurrentLoudness bool
+}
+
+type SetLoudnessRequest struct {
+	InstanceID      int
+	Channel         string
+	DesiredLoudness int
+}
+
+func (c *SoapClient) GetVolume(ctx context.Context) (int, error) {
+	resp := GetVolumeResponse{}
+	err := c.Call(ctx, renderingControlService, "GetVolume", GetVolumeRequest{Channel: "Master"}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.CurrentVolume, nil
+}
+
+func (c *SoapClient) SetVolume(ctx context.Context, volume int) error {
+	req := SetVolumeRequest{Channel: "Master", DesiredVolume: volume}
+	return c.Call(ctx, renderingControlService, "SetVolume", req, nil)
+}
+
+func (c *SoapClient) GetMute(ctx context.Context) (bool, error) {
+	resp := GetMuteResponse{}
+	err := c.Call(ctx, renderingControlService, "GetMute", GetMuteRequest{Channel: "Master"}, &resp)
+	if err != nil {
+		return false, err
+	}
+	return resp.CurrentMute, nil
+}
+
+func (c *SoapClient) SetMute(ctx context.Context, mute bool) error {
+	req := SetMuteRequest{Channel: "Master", DesiredMute: upnpBool(mute)}
+	return c.Call(ctx, renderingControlService, "SetMute", req, nil)
+}
+
+// Bass and treble range from -10 to 10.
+
+func (c *SoapClient) GetBass(ctx context.Context) (int, error) {
+	resp := GetBassResponse{}
+	err := c.Call(ctx, renderingControlService, "GetBass", GetBassRequest{}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.CurrentBass, nil
+}
+
+func (c *SoapClient) SetBass(ctx context.Context, bass int) error {
+	return c.Call(ctx, renderingControlService, "SetBass", SetBassRequest{DesiredBass: bass}, nil)
+}
+
+func (c *SoapClient) GetTreble(ctx context.Context) (int, error) {
+	resp := GetTrebleResponse{}
+	err := c.Call(ctx, renderingControlService, "GetTreble", GetTrebleRequest{}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.CurrentTreble, nil
+}
+
+func (c *SoapClient) SetTreble(ctx context.Context, treble int) error {
+	return c.Call(ctx, renderingControlService, "SetTreble", SetTrebleRequest{DesiredTreble: treble}, nil)
+}
+
+func (c *SoapClient) GetLoudness(ctx context.Context) (bool, error) {
+	resp := GetLoudnessResponse{}
+	err := c.Call(ctx, renderingControlService, "GetLoudness", GetLoudnessRequest{Channel: "Master"}, &resp)
+	if err != nil {
+		return false, err
+	}
+	return resp.CurrentLoudness, nil
+}
+
+func (c *SoapClient) SetLoudness(ctx context.Context, loudness bool) error {
+	req := SetLoudnessRequest{Channel: "Master", DesiredLoudness: upnpBool(loudness)}
+	return c.Call(ctx, renderingControlService, "SetLoudness", req, nil)
+}
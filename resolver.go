@@ -0,0 +1,85 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// StreamCandidate is one of the streams a resolver found for a video.
+type StreamCandidate struct {
+	Url        string
+	MimeType   string
+	Container  string
+	Codec      string
+	Bitrate    int
+	Resolution string
+	AudioOnly  bool
+}
+
+type Media struct {
+	Id            string
+	Title         string
+	LengthSeconds int
+	Thumbnail     string
+	Streams       []StreamCandidate
+}
+
+// Resolver looks up the metadata and playable streams of a YouTube video ID.
+// Resolving gives up when ctx is done or after Timeouts.Resolve.
+type Resolver interface {
+	Name() string
+	Resolve(ctx context.Context, id string) (Media, error)
+}
+
+var resolverNames = []string{"Invidious", "Piped", "yt-dlp"}
+
+var defaultPipedApiUrl = "https://pipedapi.kavin.rocks"
+var defaultYtDlpPath = "yt-dlp"
+
+// newResolver creates the resolver selected in the settings.
+func newResolver(prefs Preferences) Resolver {
+	switch prefs.StringWithFallback("Resolver", "Invidious") {
+	case "Piped":
+		return &PipedResolver{ApiUrl: prefs.StringWithFallback("PipedApiUrl", defaultPipedApiUrl)}
+	case "yt-dlp":
+		return &YtDlpResolver{Path: prefs.StringWithFallback("YtDlpPath", defaultYtDlpPath)}
+	default:
+		return &InvidiousResolver{Pool: invidiousPool}
+	}
+}
+
+// useSelectedResolver switches to the resolver selected in the settings.
+func useSelectedResolver(prefs Preferences) {
+	resolver := newResolver(prefs)
+	updateSettings(func(s *Settings) {
+		s.Resolver = resolver
+	})
+}
+
+// splitMimeType splits a type such as `audio/mp4; codecs="mp4a.40.2"` into
+// the MIME type and the codec list.
+func splitMimeType(mimeType string) (string, string) {
+	parts := strings.SplitN(mimeType, ";", 2)
+	if len(parts) == 1 {
+		return strings.TrimSpace(parts[0]), ""
+	}
+
+	codecs := strings.TrimSpace(parts[1])
+	codecs = strings.TrimPrefix(codecs, "codecs=")
+	codecs = strings.Trim(codecs, "\"")
+	return strings.TrimSpace(parts[0]), codecs
+}
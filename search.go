@@ -0,0 +1,105 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SearchResult is a video found by searchVideos.
+type SearchResult struct {
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	VideoId       string `json:"videoId"`
+	Author        string `json:"author"`
+	LengthSeconds int    `json:"lengthSeconds"`
+	LiveNow       bool   `json:"liveNow"`
+}
+
+// Url returns the watch URL of the video, which is what the controller
+// plays and enqueues.
+func (r SearchResult) Url() string {
+	return "https://www.youtube.com/watch?v=" + r.VideoId
+}
+
+// Details describes the result below its title.
+func (r SearchResult) Details() string {
+	if r.LiveNow {
+		return r.Author + " · Live"
+	}
+	return r.Author + " · " + formatDuration(r.LengthSeconds)
+}
+
+// isSearchQuery reports whether text typed in the input is meant as search
+// terms rather than a video or playlist URL.
+func isSearchQuery(text string) bool {
+	text = strings.TrimSpace(text)
+	if videoUrlRegexp.MatchString(text) {
+		return false
+	}
+	if _, ok := getPlaylistId(text); ok {
+		return false
+	}
+
+	// Other URLs get the error saying they aren't YouTube URLs
+	u, err := url.Parse(text)
+	return err != nil || (u.Scheme != "http" && u.Scheme != "https")
+}
+
+// searchVideos asks Invidious for a page of videos matching query. Pages
+// start at 1, a page without results means there are no more.
+func searchVideos(ctx context.Context, query string, page int) ([]SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.New("nothing to search for")
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("type", "video")
+	params.Set("page", strconv.Itoa(page))
+
+	var res []SearchResult
+	err := invidiousPool.Do(ctx, func(baseUrl string) error {
+		res = nil
+		return invidiousGet(ctx, nil, baseUrl, "/api/v1/search?"+params.Encode(), &res)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Instances don't all honour type=video
+	results := make([]SearchResult, 0, len(res))
+	for _, result := range res {
+		if result.Type != "video" || result.VideoId == "" {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// formatDuration formats seconds the way YouTube does, 3:33 or 1:00:01.
+func formatDuration(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// useInvidiousPool searches on instance until the test ends.
+func useInvidiousPool(t *testing.T, instance *fakeInvidious) {
+	previous := invidiousPool
+	t.Cleanup(func() {
+		invidiousPool = previous
+	})
+	invidiousPool = newInstancePool([]string{instance.URL()})
+}
+
+func TestSearchVideos(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+	useInvidiousPool(t, instance)
+
+	results, err := searchVideos(ctx, "  never gonna give you up ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	searches := instance.Searches()
+	if len(searches) != 1 {
+		t.Fatalf("expected a single search, got %v", searches)
+	}
+	if q := searches[0].Get("q"); q != "never gonna give you up" {
+		t.Fatalf("expected the trimmed query, got %q", q)
+	}
+	if searches[0].Get("type") != "video" || searches[0].Get("page") != "1" {
+		t.Fatalf("expected the first page of videos, got %v", searches[0])
+	}
+
+	// Channels and playlists are left out
+	checkGolden(t, "search", encodeGolden(t, results))
+
+	results, err = searchVideos(ctx, "never gonna give you up", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no more results, got %v", results)
+	}
+}
+
+func TestSearchVideosErrors(t *testing.T) {
+	ctx := context.Background()
+	instance := newFakeInvidious(t)
+	useInvidiousPool(t, instance)
+
+	_, err := searchVideos(ctx, " ", 1)
+	if err == nil || len(instance.Searches()) != 0 {
+		t.Fatalf("expected an empty query to be refused without asking, got %v", err)
+	}
+
+	instance.mu.Lock()
+	instance.Status = 503
+	instance.mu.Unlock()
+
+	_, err = searchVideos(ctx, "rick astley", 1)
+	var apiErr *InvidiousError
+	if err == nil || errors.As(err, &apiErr) {
+		t.Fatalf("expected the instance to fail, got %v", err)
+	}
+}
+
+func TestIsSearchQuery(t *testing.T) {
+	tests := []struct {
+		text   string
+		search bool
+	}{
+		{"never gonna give you up", true},
+		{"rick astley 1987", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"youtu.be/dQw4w9WgXcQ", false},
+		{"https://www.youtube.com/playlist?list=PLlaN88a7y2_plecYoJxvRFTLHVbIVAOoc", false},
+		// Not YouTube, but clearly not meant as search terms either
+		{"https://vimeo.com/76979871", false},
+	}
+
+	for _, test := range tests {
+		if got := isSearchQuery(test.text); got != test.search {
+			t.Errorf("%q: expected %v, got %v", test.text, test.search, got)
+		}
+	}
+}
+
+func TestSearchResultDetails(t *testing.T) {
+	tests := []struct {
+		result SearchResult
+		want   string
+	}{
+		{SearchResult{Author: "Rick Astley", LengthSeconds: 212}, "Rick Astley · 3:32"},
+		{SearchResult{Author: "Loop Master", LengthSeconds: 3601}, "Loop Master · 1:00:01"},
+		{SearchResult{Author: "Rick Astley", LengthSeconds: 9}, "Rick Astley · 0:09"},
+		{SearchResult{Author: "Lofi Girl", LiveNow: true}, "Lofi Girl · Live"},
+	}
+
+	for _, test := range tests {
+		if got := test.result.Details(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
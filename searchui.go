@@ -0,0 +1,221 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// searchRow is a single search result with buttons to play or enqueue it.
+type searchRow struct {
+	widget.BaseWidget
+
+	result SearchResult
+
+	image   *canvas.Image
+	title   *widget.Label
+	details *widget.Label
+	play    *widget.Button
+	enqueue *widget.Button
+}
+
+func newSearchRow(onPlay func(result SearchResult), onEnqueue func(result SearchResult)) *searchRow {
+	row := &searchRow{
+		image:   canvas.NewImageFromResource(resourceEmptythumbnailPng),
+		title:   widget.NewLabel(""),
+		details: widget.NewLabel(""),
+	}
+	row.image.SetMinSize(fyne.NewSize(96, 54))
+	row.image.FillMode = canvas.ImageFillContain
+	row.title.Wrapping = fyne.TextTruncate
+	row.details.Wrapping = fyne.TextTruncate
+	row.play = widget.NewButtonWithIcon("", theme.MediaPlayIcon(), func() {
+		onPlay(row.result)
+	})
+	row.play.Importance = widget.LowImportance
+	row.enqueue = widget.NewButtonWithIcon("", theme.ContentAddIcon(), func() {
+		onEnqueue(row.result)
+	})
+	row.enqueue.Importance = widget.LowImportance
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+func (r *searchRow) CreateRenderer() fyne.WidgetRenderer {
+	text := container.NewVBox(r.title, r.details)
+	buttons := container.NewHBox(r.play, r.enqueue)
+	return widget.NewSimpleRenderer(container.NewBorder(nil, nil, r.image, buttons, text))
+}
+
+func (r *searchRow) update(result SearchResult, refresh func()) {
+	r.result = result
+	r.title.SetText(result.Title)
+	r.details.SetText(result.Details())
+	showCachedThumbnail(r.image, result.VideoId, refresh)
+}
+
+// makeSearchPanel builds the list of search results. The returned function
+// starts a new search, searching and playing results run on activity.
+func makeSearchPanel(w fyne.Window, activity *ActivityBar) (fyne.CanvasObject, func(query string)) {
+	var mu sync.Mutex
+	var results []SearchResult
+	query := ""
+	page := 0
+	// Results of a search that was replaced by a newer one are dropped
+	generation := 0
+
+	getResults := func() []SearchResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return results
+	}
+
+	var list *widget.List
+	refresh := func() {
+		list.Refresh()
+	}
+
+	requireDevice := func() bool {
+		if !controller.HasDevice() {
+			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
+			return false
+		}
+		return true
+	}
+
+	play := func(result SearchResult) {
+		if !requireDevice() {
+			return
+		}
+		activity.Run(w, "Loading "+result.Title, func(ctx context.Context) error {
+			_, err := controller.PlayUrl(ctx, result.Url())
+			return err
+		})
+	}
+
+	enqueue := func(result SearchResult) {
+		if !requireDevice() {
+			return
+		}
+		activity.Run(w, "Adding "+result.Title, func(ctx context.Context) error {
+			_, err := controller.Enqueue(ctx, result.Url())
+			return err
+		})
+	}
+
+	list = widget.NewList(
+		func() int {
+			return len(getResults())
+		},
+		func() fyne.CanvasObject {
+			return newSearchRow(play, enqueue)
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			results := getResults()
+			if id >= len(results) {
+				return
+			}
+			item.(*searchRow).update(results[id], refresh)
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		list.UnselectAll()
+
+		results := getResults()
+		if id < len(results) {
+			play(results[id])
+		}
+	}
+
+	header := widget.NewLabel("Search for videos with the field on the left")
+	header.Wrapping = fyne.TextTruncate
+	moreButton := widget.NewButton("More results", nil)
+	moreButton.Hide()
+
+	// load fetches the next page of the current search
+	load := func() {
+		mu.Lock()
+		current := generation
+		searchQuery := query
+		nextPage := page + 1
+		mu.Unlock()
+
+		moreButton.Disable()
+		activity.Run(w, fmt.Sprintf("Searching for %q", searchQuery), func(ctx context.Context) error {
+			found, err := searchVideos(ctx, searchQuery, nextPage)
+
+			mu.Lock()
+			if current != generation {
+				mu.Unlock()
+				return nil
+			}
+			if err == nil {
+				results = append(results, found...)
+				page = nextPage
+			}
+			count := len(results)
+			mu.Unlock()
+
+			moreButton.Enable()
+			if err != nil {
+				if count == 0 {
+					header.SetText(fmt.Sprintf("Could not search for %q", searchQuery))
+				}
+				return err
+			}
+
+			if count == 0 {
+				header.SetText(fmt.Sprintf("Nothing found for %q", searchQuery))
+			} else {
+				header.SetText(fmt.Sprintf("Results for %q", searchQuery))
+			}
+			// An empty page means there is nothing left
+			if len(found) == 0 {
+				moreButton.Hide()
+			} else {
+				moreButton.Show()
+			}
+			list.Refresh()
+			return nil
+		})
+	}
+	moreButton.OnTapped = load
+
+	search := func(text string) {
+		mu.Lock()
+		generation++
+		query = text
+		page = 0
+		results = nil
+		mu.Unlock()
+
+		header.SetText(fmt.Sprintf("Searching for %q...", text))
+		moreButton.Hide()
+		list.ScrollToTop()
+		list.Refresh()
+		load()
+	}
+
+	return container.NewBorder(header, moreButton, nil, nil, list), search
+}
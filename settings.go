@@ -0,0 +1,60 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "sync"
+
+// Settings decide how streams are resolved and served. The settings window
+// changes them while the redirector and the controller use them, so they
+// are read as a copy with currentSettings and changed with updateSettings.
+type Settings struct {
+	Resolver Resolver
+	Policy   StreamPolicy
+
+	// ProxyStreams serves the speaker the stream by us instead of
+	// redirecting it to the upstream URL.
+	ProxyStreams bool
+
+	// Transcode is the format streams Sonos can't play are converted to,
+	// nil if transcoding is turned off.
+	Transcode  *TranscodeFormat
+	FfmpegPath string
+
+	// AdvertiseAddress overrides the address the speaker is told to fetch
+	// streams from, empty to detect it.
+	AdvertiseAddress string
+
+	// BindRedirector makes the redirector listen on the advertised address
+	// only instead of on every interface.
+	BindRedirector bool
+}
+
+var settings = Settings{
+	Resolver:   &InvidiousResolver{Pool: invidiousPool},
+	FfmpegPath: defaultFfmpegPath,
+}
+var settingsMutex sync.Mutex
+
+func currentSettings() Settings {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	return settings
+}
+
+func updateSettings(update func(s *Settings)) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	update(&settings)
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type Service struct {
+	Type        string
+	ControlPath string
+	EventPath   string
+}
+
+var avTransportService = Service{
+	Type:        "urn:schemas-upnp-org:service:AVTransport:1",
+	ControlPath: "/MediaRenderer/AVTransport/Control",
+	EventPath:   "/MediaRenderer/AVTransport/Event",
+}
+
+var renderingControlService = Service{
+	Type:        "urn:schemas-upnp-org:service:RenderingControl:1",
+	ControlPath: "/MediaRenderer/RenderingControl/Control",
+	EventPath:   "/MediaRenderer/RenderingControl/Event",
+}
+
+var groupRenderingControlService = Service{
+	Type:        "urn:schemas-upnp-org:service:GroupRenderingControl:1",
+	ControlPath: "/MediaRenderer/GroupRenderingControl/Control",
+	EventPath:   "/MediaRenderer/GroupRenderingControl/Event",
+}
+
+var zoneGroupTopologyService = Service{
+	Type:        "urn:schemas-upnp-org:service:ZoneGroupTopology:1",
+	ControlPath: "/ZoneGroupTopology/Control",
+	EventPath:   "/ZoneGroupTopology/Event",
+}
+
+// Descriptions for the error codes defined by the UPnP device architecture
+// and the AVTransport/RenderingControl service templates, for speakers that
+// don't send one themselves.
+var upnpErrorCodes = map[int]string{
+	401: "Invalid action",
+	402: "Invalid args",
+	501: "Action failed",
+	600: "Argument value invalid",
+	601: "Argument value out of range",
+	602: "Optional action not implemented",
+	603: "Out of memory",
+	701: "Transition not available",
+	702: "No contents",
+	703: "Read error",
+	704: "Format not supported for playback",
+	705: "Transport is locked",
+	706: "Write error",
+	707: "Media is protected or not writable",
+	708: "Format not supported for recording",
+	709: "Media is full",
+	710: "Seek mode not supported",
+	711: "Illegal seek target",
+	712: "Play mode not supported",
+	713: "Record quality not supported",
+	714: "Illegal MIME-type",
+	715: "Content busy",
+	716: "Resource not found",
+	717: "Play speed not supported",
+	718: "Invalid InstanceID",
+}
+
+// UPnPError is returned when a speaker answers an action with a SOAP fault.
+type UPnPError struct {
+	Action      string
+	Code        int
+	Description string
+}
+
+func (e *UPnPError) Error() string {
+	description := e.Description
+	if description == "" {
+		description = upnpErrorCodes[e.Code]
+	}
+	if description == "" {
+		return fmt.Sprintf("%s failed: UPnP error %d", e.Action, e.Code)
+	}
+	return fmt.Sprintf("%s failed: UPnP error %d (%s)", e.Action, e.Code, description)
+}
+
+type soapEnvelope struct {
+	XMLName       xml.Name `xml:"s:Envelope"`
+	Xmlns         string   `xml:"xmlns:s,attr"`
+	EncodingStyle string   `xml:"s:encodingStyle,attr"`
+	Body          struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"s:Body"`
+}
+
+type soapFault struct {
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+	Detail      struct {
+		UPnPError struct {
+			ErrorCode        int    `xml:"errorCode"`
+			ErrorDescription string `xml:"errorDescription"`
+		} `xml:"UPnPError"`
+	} `xml:"detail"`
+}
+
+type soapResponse struct {
+	Body struct {
+		Fault   *soapFault `xml:"Fault"`
+		Content []byte     `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// SoapClient sends UPnP control actions to a single speaker.
+type SoapClient struct {
+	Host   string
+	Client *http.Client
+}
+
+func newSoapClient(host string) *SoapClient {
+	return &SoapClient{Host: host}
+}
+
+// Call invokes action on service, encoding request as the action arguments
+// and decoding the action response into response. response may be nil. It
+// gives up after Timeouts.Speaker, or when ctx is done.
+func (c *SoapClient) Call(ctx context.Context, service Service, action string, request interface{}, response interface{}) error {
+	u, err := url.Parse(c.Host)
+	if err != nil {
+		return err
+	}
+	u.Path = service.ControlPath
+
+	var args bytes.Buffer
+	enc := xml.NewEncoder(&args)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "u:" + action},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:u"}, Value: service.Type}},
+	}
+	err = enc.EncodeElement(request, start)
+	if err != nil {
+		return err
+	}
+
+	envelope := soapEnvelope{
+		Xmlns:         "http://schemas.xmlsoap.org/soap/envelope/",
+		EncodingStyle: "http://schemas.xmlsoap.org/soap/encoding/",
+	}
+	envelope.Body.Content = args.Bytes()
+
+	body, err := xml.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Speaker)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/xml; charset=\"utf-8\"")
+	req.Header["SOAPACTION"] = []string{service.Type + "#" + action}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	envelopeResp := soapResponse{}
+	xmlErr := xml.Unmarshal(bodyBytes, &envelopeResp)
+
+	// The faultstring is always just "UPnPError", the description is in
+	// the detail when the speaker sends one at all
+	if xmlErr == nil && envelopeResp.Body.Fault != nil {
+		fault := envelopeResp.Body.Fault
+		return &UPnPError{
+			Action:      action,
+			Code:        fault.Detail.UPnPError.ErrorCode,
+			Description: fault.Detail.UPnPError.ErrorDescription,
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed: %s", action, resp.Status)
+	}
+
+	if xmlErr != nil {
+		return fmt.Errorf("%s failed: invalid response: %w", action, xmlErr)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return xml.Unmarshal(envelopeResp.Body.Content, response)
+}
+
+// upnpBool encodes a boolean argument the way UPnP expects it, as 0 or 1.
+func upnpBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
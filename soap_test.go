@@ -0,0 +1,79 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUPnPErrorDescription(t *testing.T) {
+	tests := []struct {
+		err  UPnPError
+		want string
+	}{
+		// What the speaker says wins over the table
+		{UPnPError{Action: "Play", Code: 701, Description: "Nothing queued"}, "Play failed: UPnP error 701 (Nothing queued)"},
+		{UPnPError{Action: "Play", Code: 701}, "Play failed: UPnP error 701 (Transition not available)"},
+		{UPnPError{Action: "SetAVTransportURI", Code: 714}, "SetAVTransportURI failed: UPnP error 714 (Illegal MIME-type)"},
+		{UPnPError{Action: "Seek", Code: 800}, "Seek failed: UPnP error 800"},
+	}
+
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestCallFault(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Living Room")
+	client := newSoapClient(speaker.Device().Host)
+
+	// Sonos only sends the code, so the description comes from the table
+	err := client.Play(ctx)
+	want := "Play failed: UPnP error 701 (Transition not available)"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+
+	err = client.Seek(ctx, 83)
+	want = "Seek failed: UPnP error 701 (Transition not available)"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+
+	// A speaker that does describe the error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+
+			`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+			`<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+			`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>701</errorCode>`+
+			`<errorDescription>Nothing queued</errorDescription></UPnPError></detail>`+
+			`</s:Fault></s:Body></s:Envelope>`)
+	}))
+	defer server.Close()
+
+	err = newSoapClient(server.URL).Play(ctx)
+	want = "Play failed: UPnP error 701 (Nothing queued)"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestController returns a controller of its own with speaker selected.
+func newTestController(speaker *fakeSpeaker) *Controller {
+	c := newController(&ZoneGroupTopology{}, &EventBus{})
+	c.SelectDevice(speaker.Device())
+	return c
+}
+
+func expectUPnPError(t *testing.T, err error, code int) {
+	t.Helper()
+
+	upnpErr := &UPnPError{}
+	if !errors.As(err, &upnpErr) {
+		t.Fatalf("expected UPnP error %d, got %v", code, err)
+	}
+	if upnpErr.Code != code {
+		t.Fatalf("expected UPnP error %d, got %d", code, upnpErr.Code)
+	}
+}
+
+func pollStatus(t *testing.T, c *Controller) PlaybackStatus {
+	t.Helper()
+
+	status, err := c.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestDiscoverySearch(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Kitchen")
+
+	d := &Discovery{SearchAddress: speaker.ssdpAddress}
+	err := d.Search(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := d.Devices()
+	if len(devices) != 1 || devices[0] != speaker.Device() {
+		t.Fatalf("expected %v, got %v", speaker.Device(), devices)
+	}
+
+	device, ok := d.Find("Kitchen (Play:1)")
+	if !ok || device.UDN != speaker.udn {
+		t.Fatalf("could not find the speaker by name")
+	}
+}
+
+func TestDiscoveryAnnouncements(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Bedroom")
+
+	d := &Discovery{}
+	changes := 0
+	d.Watch(func() {
+		changes++
+	})
+
+	header := http.Header{}
+	header.Set("NT", zonePlayerType)
+	header.Set("NTS", "ssdp:alive")
+	header.Set("USN", speaker.udn+"::"+zonePlayerType)
+	header.Set("Location", speaker.location())
+	header.Set("Cache-Control", "max-age = 1800")
+	d.handleNotify(header)
+
+	// Repeated announcements only extend the max-age
+	d.handleNotify(header)
+
+	if _, ok := d.FindUDN(speaker.udn); !ok || changes != 1 {
+		t.Fatalf("expected the speaker to be added once, got %v after %d changes", d.Devices(), changes)
+	}
+
+	header.Set("NTS", "ssdp:byebye")
+	d.handleNotify(header)
+
+	if len(d.Devices()) != 0 || changes != 2 {
+		t.Fatalf("expected the speaker to be removed, got %v after %d changes", d.Devices(), changes)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Living Room")
+	c := newTestController(speaker)
+
+	// Nothing to play yet
+	expectUPnPError(t, c.Play(ctx), 701)
+
+	err := newSoapClient(c.TransportDevice().Host).SetAVTransportURI(ctx, "http://127.0.0.1:9372/test.mp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	speaker.SetDuration(212)
+
+	steps := []struct {
+		name     string
+		action   func(ctx context.Context) error
+		state    string
+		position int
+	}{
+		{"play", c.Play, transportPlaying, 0},
+		{"seek", func(ctx context.Context) error { return c.Seek(ctx, 83) }, transportPlaying, 83},
+		{"pause", c.Pause, transportPaused, 83},
+		{"resume", c.Play, transportPlaying, 83},
+		{"stop", c.Stop, transportStopped, 0},
+	}
+
+	for _, step := range steps {
+		err := step.action(ctx)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		status := pollStatus(t, c)
+		if status.State != step.state || status.Position != step.position {
+			t.Fatalf("%s: expected %s at %d, got %s at %d", step.name, step.state, step.position, status.State, status.Position)
+		}
+		if status.Duration != 212 || status.Uri != "http://127.0.0.1:9372/test.mp4" {
+			t.Fatalf("%s: unexpected track %+v", step.name, status)
+		}
+	}
+
+	// Past the end of the track
+	expectUPnPError(t, c.Seek(ctx, 300), 711)
+	// Only a playing speaker can be paused
+	expectUPnPError(t, c.Pause(ctx), 701)
+}
+
+func TestVolume(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Office")
+	c := newTestController(speaker)
+
+	volume, err := c.Volume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 20 {
+		t.Fatalf("expected the initial volume of 20, got %d", volume)
+	}
+
+	err = c.SetVolume(ctx, 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err = c.Volume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, speakerVolume := speaker.State(); volume != 35 || speakerVolume != 35 {
+		t.Fatalf("expected volume 35, got %d (speaker %d)", volume, speakerVolume)
+	}
+
+	expectUPnPError(t, c.SetVolume(ctx, 101), 601)
+
+	client := newSoapClient(c.Device().Host)
+	err = client.SetMute(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	muted, err := client.GetMute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !muted {
+		t.Fatal("expected the speaker to be muted")
+	}
+}
+
+func TestQueuePlayback(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Garden")
+	c := newTestController(speaker)
+	queue := c.Queue()
+
+	for _, id := range []string{"first", "second"} {
+		_, err := queue.Add(ctx, Track{YtId: id, Title: id, Uri: "http://127.0.0.1:9372/" + id + ".mp4"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first track added takes over the speaker queue
+	if speaker.Actions[0] != "RemoveAllTracksFromQueue" {
+		t.Fatalf("expected the queue to be cleared first, got %v", speaker.Actions)
+	}
+
+	err := queue.PlayIndex(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := pollStatus(t, c)
+	if status.State != transportPlaying || status.Track != 2 || status.Uri != "http://127.0.0.1:9372/second.mp4" {
+		t.Fatalf("expected the second track to play, got %+v", status)
+	}
+
+	err = queue.Next(ctx)
+	if err == nil {
+		t.Fatal("expected no next track after the last one")
+	}
+
+	err = queue.Previous(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := pollStatus(t, c); status.Track != 1 || queue.Current() != 0 {
+		t.Fatalf("expected to be back at the first track, got %d (queue at %d)", status.Track, queue.Current())
+	}
+}
+
+func TestQueueSlowSpeaker(t *testing.T) {
+	useTimeouts(t, Timeouts{Speaker: time.Minute})
+	server := newHungServer(t)
+
+	c := newController(&ZoneGroupTopology{}, &EventBus{})
+	c.SelectDevice(Device{Name: "Hung", Host: server.URL, UDN: "uuid:RINCON_HUNG"})
+	queue := c.Queue()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := queue.Add(ctx, Track{YtId: "slow", Title: "slow", Uri: "http://127.0.0.1:9372/slow.mp4"})
+		done <- err
+	}()
+
+	// Reading the queue doesn't wait for the speaker
+	time.Sleep(50 * time.Millisecond)
+	expectWithin(t, 100*time.Millisecond, func() error {
+		queue.Items()
+		queue.Current()
+		return nil
+	})
+
+	// Neither does switching speakers, which drops the change in flight
+	queue.Reset()
+	cancel()
+	err := <-done
+	if err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	if len(queue.Items()) != 0 {
+		t.Fatalf("expected an empty queue, got %v", queue.Items())
+	}
+}
+
+func TestGenaEvents(t *testing.T) {
+	ctx := context.Background()
+	speaker := newFakeSpeaker(t, "Study")
+	c := newTestController(speaker)
+
+	events := make(chan map[string]string, 16)
+	subscriber := &GenaSubscriber{
+		OnEvent: func(sub Subscription, values map[string]string) {
+			events <- values
+		},
+	}
+
+	callback := httptest.NewServer(subscriber)
+	t.Cleanup(callback.Close)
+	subscriber.CallbackUrl = func(host string) (string, error) {
+		return callback.URL + genaCallbackPath, nil
+	}
+
+	// Events of both services arrive in any order, keep what was seen
+	latest := make(map[string]string)
+	waitFor := func(name string, value string) {
+		t.Helper()
+
+		timeout := time.After(5 * time.Second)
+		for latest[name] != value {
+			select {
+			case values := <-events:
+				for k, v := range values {
+					latest[k] = v
+				}
+			case <-timeout:
+				t.Fatalf("no event with %s=%s, last was %q", name, value, latest[name])
+			}
+		}
+	}
+
+	for _, service := range []Service{avTransportService, renderingControlService} {
+		err := subscriber.Subscribe(ctx, c.Device().Host, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every subscription starts with the current state
+	waitFor("TransportState", transportNoMedia)
+	waitFor("Volume", "20")
+
+	err := newSoapClient(c.Device().Host).SetAVTransportURI(ctx, "http://127.0.0.1:9372/test.mp4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Play(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor("TransportState", transportPlaying)
+
+	err = c.SetVolume(ctx, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor("Volume", "40")
+
+	if len(subscriber.Subscriptions()) != 2 {
+		t.Fatalf("expected 2 subscriptions, got %v", subscriber.Subscriptions())
+	}
+
+	subscriber.Unsubscribe(ctx)
+	speaker.mu.Lock()
+	remaining := len(speaker.subs)
+	speaker.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("expected the speaker to forget the subscriptions, %d left", remaining)
+	}
+}
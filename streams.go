@@ -0,0 +1,156 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The containers Sonos can play, with the MIME type announced for them in
+// the protocolInfo of the track metadata.
+var sonosMimeTypes = map[string]string{
+	"m4a":  "audio/mp4",
+	"mp4":  "audio/mp4",
+	"mp3":  "audio/mpeg",
+	"flac": "audio/flac",
+}
+
+// StreamPolicy decides which of the streams of a video is sent to the speaker.
+// Audio-only AAC streams are preferred, the best one within MaxBitrate
+// first, then the smallest one above it. Muxed MP4 video is the last resort.
+type StreamPolicy struct {
+	// MaxBitrate is the bitrate ceiling in bits per second, 0 means no ceiling.
+	MaxBitrate int
+}
+
+func sonosCompatible(candidate StreamCandidate) bool {
+	if _, ok := sonosMimeTypes[candidate.Container]; !ok {
+		return false
+	}
+
+	if candidate.Container == "mp4" || candidate.Container == "m4a" {
+		// An unknown codec is most likely AAC, which is all YouTube muxes into MP4
+		return candidate.Codec == "" || strings.Contains(candidate.Codec, "mp4a")
+	}
+
+	return true
+}
+
+func (p StreamPolicy) tier(candidate StreamCandidate) int {
+	if !candidate.AudioOnly {
+		return 2
+	}
+	if p.MaxBitrate > 0 && candidate.Bitrate > p.MaxBitrate {
+		return 1
+	}
+	return 0
+}
+
+// less reports whether stream a is preferable to stream b.
+func (p StreamPolicy) less(a StreamCandidate, b StreamCandidate) bool {
+	tierA, tierB := p.tier(a), p.tier(b)
+	if tierA != tierB {
+		return tierA < tierB
+	}
+
+	switch tierA {
+	case 0:
+		return a.Bitrate > b.Bitrate
+	case 1:
+		return a.Bitrate < b.Bitrate
+	default:
+		return resolutionHeight(a.Resolution) < resolutionHeight(b.Resolution)
+	}
+}
+
+// Select returns the preferred Sonos-compatible stream of streams.
+func (p StreamPolicy) Select(streams []StreamCandidate) (StreamCandidate, error) {
+	if len(streams) == 0 {
+		return StreamCandidate{}, errors.New("no streams available, the video might be a live stream")
+	}
+
+	var compatible []StreamCandidate
+	for _, candidate := range streams {
+		if sonosCompatible(candidate) {
+			compatible = append(compatible, candidate)
+		}
+	}
+
+	if len(compatible) == 0 {
+		return StreamCandidate{}, fmt.Errorf("no Sonos-compatible stream available, only %s", describeStreams(streams))
+	}
+
+	sort.SliceStable(compatible, func(i, j int) bool {
+		return p.less(compatible[i], compatible[j])
+	})
+
+	return compatible[0], nil
+}
+
+// SelectForTranscoding returns the stream to transcode when none of streams
+// can be played directly, preferring the best audio-only stream.
+func (p StreamPolicy) SelectForTranscoding(streams []StreamCandidate) (StreamCandidate, error) {
+	if len(streams) == 0 {
+		return StreamCandidate{}, errors.New("no streams available, the video might be a live stream")
+	}
+
+	candidates := make([]StreamCandidate, len(streams))
+	copy(candidates, streams)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return p.less(candidates[i], candidates[j])
+	})
+
+	return candidates[0], nil
+}
+
+func resolutionHeight(resolution string) int {
+	height, err := strconv.Atoi(strings.TrimSuffix(resolution, "p"))
+	if err != nil {
+		return 0
+	}
+	return height
+}
+
+// describeStreams lists the distinct container/codec combinations of streams.
+func describeStreams(streams []StreamCandidate) string {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, candidate := range streams {
+		format := candidate.Container
+		if candidate.Codec != "" {
+			format += "/" + candidate.Codec
+		}
+		if format == "" || seen[format] {
+			continue
+		}
+		seen[format] = true
+		formats = append(formats, format)
+	}
+	return strings.Join(formats, ", ")
+}
+
+// protocolInfo returns the protocolInfo of the DIDL-Lite <res> element for candidate.
+func protocolInfo(candidate StreamCandidate) string {
+	mimeType, ok := sonosMimeTypes[candidate.Container]
+	if !ok {
+		mimeType = candidate.MimeType
+	}
+	return fmt.Sprintf("http-get:*:%s:*", mimeType)
+}
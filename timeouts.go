@@ -0,0 +1,92 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Timeouts limits how long a single network operation of each kind may
+// take, so an unreachable speaker or a hung Invidious instance fails
+// instead of blocking whatever is waiting for it.
+type Timeouts struct {
+	// Speaker is used for SOAP actions and event subscriptions
+	Speaker time.Duration
+	// Discovery is used for searching and fetching device descriptions
+	Discovery time.Duration
+	// Resolve is used for every request to a resolver, and for running yt-dlp
+	Resolve   time.Duration
+	Thumbnail time.Duration
+}
+
+var defaultTimeouts = Timeouts{
+	Speaker:   5 * time.Second,
+	Discovery: 5 * time.Second,
+	Resolve:   30 * time.Second,
+	Thumbnail: 10 * time.Second,
+}
+
+// The timeouts are changed in the settings while requests are running, so
+// they are only replaced as a whole.
+var timeouts = defaultTimeouts
+var timeoutsMutex sync.Mutex
+
+func currentTimeouts() Timeouts {
+	timeoutsMutex.Lock()
+	defer timeoutsMutex.Unlock()
+	return timeouts
+}
+
+func setTimeouts(replacement Timeouts) {
+	timeoutsMutex.Lock()
+	defer timeoutsMutex.Unlock()
+	timeouts = replacement
+}
+
+// timeoutSettings are the preferences the timeouts are stored in, in seconds.
+var timeoutSettings = []struct {
+	Key     string
+	Label   string
+	Timeout func(t *Timeouts) *time.Duration
+	Default time.Duration
+}{
+	{"SpeakerTimeout", "Speaker", func(t *Timeouts) *time.Duration { return &t.Speaker }, defaultTimeouts.Speaker},
+	{"DiscoveryTimeout", "Discovery", func(t *Timeouts) *time.Duration { return &t.Discovery }, defaultTimeouts.Discovery},
+	{"ResolveTimeout", "Resolver", func(t *Timeouts) *time.Duration { return &t.Resolve }, defaultTimeouts.Resolve},
+	{"ThumbnailTimeout", "Thumbnails", func(t *Timeouts) *time.Duration { return &t.Thumbnail }, defaultTimeouts.Thumbnail},
+}
+
+// loadTimeouts sets the timeouts from prefs. Missing or invalid values
+// keep the default.
+func loadTimeouts(prefs Preferences) {
+	loaded := defaultTimeouts
+	for _, setting := range timeoutSettings {
+		seconds := prefs.Int(setting.Key)
+		if seconds > 0 {
+			*setting.Timeout(&loaded) = time.Duration(seconds) * time.Second
+		}
+	}
+	setTimeouts(loaded)
+}
+
+// withTimeout is context.WithTimeout, except that a timeout of 0 means none.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
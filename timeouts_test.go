@@ -0,0 +1,163 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useTimeouts replaces the timeouts until the test ends.
+func useTimeouts(t *testing.T, replacement Timeouts) {
+	saved := currentTimeouts()
+	setTimeouts(replacement)
+	t.Cleanup(func() {
+		setTimeouts(saved)
+	})
+}
+
+// newHungServer returns a server that never answers until the request is
+// given up on.
+func newHungServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() {
+		close(release)
+	})
+	return server
+}
+
+// expectWithin runs fn and fails when it takes longer than limit.
+func expectWithin(t *testing.T, limit time.Duration, fn func() error) error {
+	t.Helper()
+
+	start := time.Now()
+	err := fn()
+	if elapsed := time.Since(start); elapsed > limit {
+		t.Fatalf("expected to give up within %s, took %s", limit, elapsed)
+	}
+	return err
+}
+
+func TestSpeakerTimeout(t *testing.T) {
+	useTimeouts(t, Timeouts{Speaker: 50 * time.Millisecond})
+	server := newHungServer(t)
+
+	c := newController(&ZoneGroupTopology{}, &EventBus{})
+	c.SelectDevice(Device{Name: "Hung", Host: server.URL, UDN: "uuid:RINCON_HUNG"})
+
+	err := expectWithin(t, time.Second, func() error {
+		return c.Play(context.Background())
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	// The caller can give up sooner than the timeout
+	useTimeouts(t, Timeouts{Speaker: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err = expectWithin(t, time.Second, func() error {
+		return c.Pause(ctx)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the call to be cancelled, got %v", err)
+	}
+	if c.Status().State == transportPaused {
+		t.Fatal("expected a cancelled pause not to change the state")
+	}
+}
+
+func TestResolverTimeout(t *testing.T) {
+	useTimeouts(t, Timeouts{Resolve: 50 * time.Millisecond})
+	hung := newHungServer(t)
+	instance := newFakeInvidious(t)
+
+	// A hung instance counts as failed and the next one is tried
+	pool := newInstancePool([]string{hung.URL, instance.URL()})
+	resolver := &InvidiousResolver{Pool: pool}
+
+	media, err := resolver.Resolve(context.Background(), "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.Id != "dQw4w9WgXcQ" {
+		t.Fatalf("expected the video from the working instance, got %+v", media)
+	}
+	for _, checked := range pool.Instances() {
+		if checked.Url == hung.URL && checked.Failures != 1 {
+			t.Fatalf("expected the hung instance to have failed once, got %+v", checked)
+		}
+	}
+}
+
+func TestResolverCancel(t *testing.T) {
+	useTimeouts(t, Timeouts{Resolve: time.Minute})
+	hung := newHungServer(t)
+	instance := newFakeInvidious(t)
+
+	pool := newInstancePool([]string{hung.URL, instance.URL()})
+	resolver := &InvidiousResolver{Pool: pool}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := expectWithin(t, time.Second, func() error {
+		_, err := resolver.Resolve(ctx, "dQw4w9WgXcQ")
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the resolve to be cancelled, got %v", err)
+	}
+
+	// Giving up says nothing about the instances, and stops the failover
+	for _, checked := range pool.Instances() {
+		if checked.Failures != 0 {
+			t.Fatalf("expected no failures to be recorded, got %+v", checked)
+		}
+	}
+	if requests := instance.Requests(); len(requests) != 0 {
+		t.Fatalf("expected the next instance not to be tried, got %v", requests)
+	}
+}
+
+func TestDiscoverySearchCancel(t *testing.T) {
+	speaker := newFakeSpeaker(t, "Porch")
+	d := &Discovery{SearchAddress: speaker.ssdpAddress}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := expectWithin(t, time.Second, func() error {
+		return d.Search(ctx)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the search to be cancelled, got %v", err)
+	}
+	if len(d.Devices()) != 0 {
+		t.Fatalf("expected no devices, got %v", d.Devices())
+	}
+}
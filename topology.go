@@ -0,0 +1,266 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type GetZoneGroupStateRequest struct{}
+
+type GetZoneGroupStateResponse struct {
+	ZoneGroupState string
+}
+
+type BecomeCoordinatorOfStandaloneGroupRequest struct {
+	InstanceID int
+}
+
+func (c *SoapClient) GetZoneGroupState(ctx context.Context) (string, error) {
+	resp := GetZoneGroupStateResponse{}
+	err := c.Call(ctx, zoneGroupTopologyService, "GetZoneGroupState", GetZoneGroupStateRequest{}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.ZoneGroupState, nil
+}
+
+// BecomeCoordinatorOfStandaloneGroup takes the speaker out of its group.
+func (c *SoapClient) BecomeCoordinatorOfStandaloneGroup(ctx context.Context) error {
+	req := BecomeCoordinatorOfStandaloneGroupRequest{}
+	return c.Call(ctx, avTransportService, "BecomeCoordinatorOfStandaloneGroup", req, nil)
+}
+
+type ZoneMember struct {
+	UUID      string `xml:"UUID,attr"`
+	ZoneName  string `xml:"ZoneName,attr"`
+	Location  string `xml:"Location,attr"`
+	Invisible bool   `xml:"Invisible,attr"`
+}
+
+// Host returns the address SOAP requests for the member go to.
+func (m ZoneMember) Host() string {
+	u, err := url.Parse(m.Location)
+	if err != nil {
+		return ""
+	}
+	return "http://" + u.Host
+}
+
+type ZoneGroup struct {
+	Id          string       `xml:"ID,attr"`
+	Coordinator string       `xml:"Coordinator,attr"`
+	Members     []ZoneMember `xml:"ZoneGroupMember"`
+}
+
+// CoordinatorMember returns the member that coordinates the group.
+func (g ZoneGroup) CoordinatorMember() (ZoneMember, bool) {
+	for _, member := range g.Members {
+		if member.UUID == g.Coordinator {
+			return member, true
+		}
+	}
+	return ZoneMember{}, false
+}
+
+// Name lists the rooms in the group, coordinator first.
+func (g ZoneGroup) Name() string {
+	coordinator, _ := g.CoordinatorMember()
+	names := []string{coordinator.ZoneName}
+	for _, member := range g.VisibleMembers() {
+		if member.UUID != g.Coordinator {
+			names = append(names, member.ZoneName)
+		}
+	}
+	return strings.Join(names, " + ")
+}
+
+// VisibleMembers leaves out bonded speakers like surrounds and subs, which
+// can't be controlled on their own.
+func (g ZoneGroup) VisibleMembers() []ZoneMember {
+	var members []ZoneMember
+	for _, member := range g.Members {
+		if !member.Invisible {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+type zoneGroupState struct {
+	// Newer firmware wraps the groups in ZoneGroupState, older firmware
+	// returns ZoneGroups as the root element
+	Groups     []ZoneGroup `xml:"ZoneGroups>ZoneGroup"`
+	RootGroups []ZoneGroup `xml:"ZoneGroup"`
+}
+
+func parseZoneGroupState(state string) ([]ZoneGroup, error) {
+	parsed := zoneGroupState{}
+	err := xml.Unmarshal([]byte(state), &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := append(parsed.Groups, parsed.RootGroups...)
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name() < groups[j].Name()
+	})
+	return groups, nil
+}
+
+// ZoneGroupTopology keeps track of how the speakers are grouped. Transport
+// commands have to go to the coordinator of a group, the other members
+// reject them.
+type ZoneGroupTopology struct {
+	mu       sync.Mutex
+	groups   []ZoneGroup
+	watchers map[int]func()
+	nextId   int
+}
+
+var topology = &ZoneGroupTopology{}
+
+// Watch calls fn after the groups were updated. The returned function
+// stops watching.
+func (t *ZoneGroupTopology) Watch(fn func()) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.watchers == nil {
+		t.watchers = make(map[int]func())
+	}
+	id := t.nextId
+	t.nextId++
+	t.watchers[id] = fn
+
+	return func() {
+		t.mu.Lock()
+		delete(t.watchers, id)
+		t.mu.Unlock()
+	}
+}
+
+// Groups returns the known groups sorted by name.
+func (t *ZoneGroupTopology) Groups() []ZoneGroup {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ZoneGroup(nil), t.groups...)
+}
+
+// Update replaces the groups with the ones in a ZoneGroupState document.
+func (t *ZoneGroupTopology) Update(state string) error {
+	groups, err := parseZoneGroupState(state)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.groups = groups
+	watchers := make([]func(), 0, len(t.watchers))
+	for _, fn := range t.watchers {
+		watchers = append(watchers, fn)
+	}
+	t.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn()
+	}
+	return nil
+}
+
+// Refresh asks the speaker at host for the current groups. Every speaker
+// knows the topology of the whole household.
+func (t *ZoneGroupTopology) Refresh(ctx context.Context, host string) error {
+	state, err := newSoapClient(host).GetZoneGroupState(ctx)
+	if err != nil {
+		return err
+	}
+	return t.Update(state)
+}
+
+// Run refreshes the groups through the speaker returned by device every
+// interval. Changes normally arrive as events, this catches missed ones.
+func (t *ZoneGroupTopology) Run(interval time.Duration, device func() Device) {
+	for range time.Tick(interval) {
+		selected := device()
+		if (Device{}) == selected {
+			continue
+		}
+
+		err := t.Refresh(context.Background(), selected.Host)
+		if err != nil {
+			log.Printf("Could not get zone groups: %s", err)
+		}
+	}
+}
+
+// GroupOf returns the group the speaker with udn is a member of.
+func (t *ZoneGroupTopology) GroupOf(udn string) (ZoneGroup, bool) {
+	uuid := strings.TrimPrefix(udn, "uuid:")
+	for _, group := range t.Groups() {
+		for _, member := range group.Members {
+			if member.UUID == uuid {
+				return group, true
+			}
+		}
+	}
+	return ZoneGroup{}, false
+}
+
+// Coordinator returns the device transport commands for device have to be
+// sent to. That is device itself when it isn't grouped or the topology
+// isn't known.
+func (t *ZoneGroupTopology) Coordinator(device Device) Device {
+	group, ok := t.GroupOf(device.UDN)
+	if !ok || "uuid:"+group.Coordinator == device.UDN {
+		return device
+	}
+
+	coordinator, ok := group.CoordinatorMember()
+	if !ok || coordinator.Host() == "" {
+		return device
+	}
+
+	return Device{
+		Name: coordinator.ZoneName,
+		Host: coordinator.Host(),
+		UDN:  "uuid:" + coordinator.UUID,
+	}
+}
+
+// Join adds member to the group coordinated by coordinator.
+func (t *ZoneGroupTopology) Join(ctx context.Context, member ZoneMember, coordinator ZoneMember) error {
+	err := newSoapClient(member.Host()).SetAVTransportURI(ctx, "x-rincon:"+coordinator.UUID, "")
+	if err != nil {
+		return err
+	}
+	return t.Refresh(ctx, member.Host())
+}
+
+// Unjoin takes member out of its group.
+func (t *ZoneGroupTopology) Unjoin(ctx context.Context, member ZoneMember) error {
+	err := newSoapClient(member.Host()).BecomeCoordinatorOfStandaloneGroup(ctx)
+	if err != nil {
+		return err
+	}
+	return t.Refresh(ctx, member.Host())
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+type TranscodeFormat struct {
+	Name      string
+	Extension string
+	MimeType  string
+	Args      []string
+}
+
+var transcodeFormats = []TranscodeFormat{
+	{
+		Name:      "AAC",
+		Extension: "aac",
+		MimeType:  "audio/aac",
+		Args:      []string{"-c:a", "aac", "-b:a", "192k", "-f", "adts"},
+	},
+	{
+		Name:      "MP3",
+		Extension: "mp3",
+		MimeType:  "audio/mpeg",
+		Args:      []string{"-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3"},
+	},
+	{
+		Name:      "FLAC",
+		Extension: "flac",
+		MimeType:  "audio/flac",
+		Args:      []string{"-c:a", "flac", "-f", "flac"},
+	},
+}
+
+var defaultFfmpegPath = "ffmpeg"
+
+func findTranscodeFormat(name string) *TranscodeFormat {
+	for i := range transcodeFormats {
+		if transcodeFormats[i].Name == name {
+			return &transcodeFormats[i]
+		}
+	}
+	return nil
+}
+
+// transcodeStream converts the upstream stream with ffmpeg while it is sent
+// to the speaker. The length of the result isn't known up front, so range
+// requests are answered with the whole stream.
+func transcodeStream(w http.ResponseWriter, r *http.Request, upstream string, format *TranscodeFormat) {
+	w.Header().Set("Content-Type", format.MimeType)
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	resp, err := fetchUpstream(r.Context(), http.MethodGet, upstream, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+
+	end := int64(-1)
+	if resp.ContentLength >= 0 {
+		end = resp.ContentLength - 1
+	}
+
+	reader := &upstreamReader{
+		ctx:      r.Context(),
+		upstream: upstream,
+		body:     resp.Body,
+		end:      end,
+	}
+	defer reader.Close()
+
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-vn"}
+	args = append(args, format.Args...)
+	args = append(args, "pipe:1")
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(r.Context(), currentSettings().FfmpegPath, args...)
+	cmd.Stdin = reader
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	w.WriteHeader(http.StatusOK)
+
+	err = cmd.Run()
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Transcoding to %s failed: %s: %s", format.Name, err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// transcodeProtocolInfo returns the protocolInfo of a stream transcoded to format.
+func transcodeProtocolInfo(format *TranscodeFormat) string {
+	return fmt.Sprintf("http-get:*:%s:*", format.MimeType)
+}
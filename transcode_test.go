@@ -0,0 +1,239 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeFfmpeg is a stand-in for ffmpeg. It notes its PID and arguments in
+// $FFMPEG_STATE and copies its input to its output unchanged, or hangs
+// regardless of its input when $FFMPEG_HANG is set.
+const fakeFfmpeg = `#!/bin/sh
+echo "$@" > "$FFMPEG_STATE/args"
+echo $$ > "$FFMPEG_STATE/pid.tmp"
+mv "$FFMPEG_STATE/pid.tmp" "$FFMPEG_STATE/pid"
+if [ -n "$FFMPEG_HANG" ]; then
+	exec sleep 60
+fi
+exec cat
+`
+
+// useFakeFfmpeg transcodes with the fake ffmpeg until the test ends and
+// returns the directory it leaves its state in.
+func useFakeFfmpeg(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ffmpeg")
+	err := os.WriteFile(path, []byte(fakeFfmpeg), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := filepath.Join(dir, "state")
+	err = os.Mkdir(state, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FFMPEG_STATE", state)
+
+	useSettings(t, func(s *Settings) {
+		s.FfmpegPath = path
+	})
+	return state
+}
+
+// newTranscodeServer transcodes upstream to format for every request.
+func newTranscodeServer(t *testing.T, upstream string, format *TranscodeFormat) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transcodeStream(w, r, upstream, format)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTranscodeStream(t *testing.T) {
+	state := useFakeFfmpeg(t)
+	audio := strings.Repeat("not really audio ", 1000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, audio)
+	}))
+	defer upstream.Close()
+
+	for i := range transcodeFormats {
+		format := &transcodeFormats[i]
+		server := newTranscodeServer(t, upstream.URL, format)
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %s", format.Name, resp.Status)
+		}
+		if got := resp.Header.Get("Content-Type"); got != format.MimeType {
+			t.Errorf("%s: expected %s, got %s", format.Name, format.MimeType, got)
+		}
+		if string(body) != audio {
+			t.Errorf("%s: expected the upstream stream to go through ffmpeg, got %d bytes", format.Name, len(body))
+		}
+
+		args, err := os.ReadFile(filepath.Join(state, "args"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "-i pipe:0 -vn " + strings.Join(format.Args, " ") + " pipe:1"
+		if !strings.Contains(string(args), want) {
+			t.Errorf("%s: expected ffmpeg to be run with %q, got %q", format.Name, want, args)
+		}
+	}
+}
+
+func TestTranscodeStreamHead(t *testing.T) {
+	state := useFakeFfmpeg(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected HEAD to be answered without the upstream")
+	}))
+	defer upstream.Close()
+
+	format := findTranscodeFormat("FLAC")
+	server := newTranscodeServer(t, upstream.URL, format)
+
+	resp, err := http.Head(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != format.MimeType {
+		t.Errorf("expected 200 with %s, got %s with %s", format.MimeType, resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if _, err := os.Stat(filepath.Join(state, "pid")); err == nil {
+		t.Error("expected HEAD not to start ffmpeg")
+	}
+}
+
+func TestTranscodeStreamUpstreamError(t *testing.T) {
+	state := useFakeFfmpeg(t)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "expired", http.StatusForbidden)
+	}))
+	defer upstream.Close()
+
+	server := newTranscodeServer(t, upstream.URL, findTranscodeFormat("MP3"))
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the upstream status, got %s", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(state, "pid")); err == nil {
+		t.Error("expected ffmpeg not to be started for a failed upstream")
+	}
+}
+
+func TestTranscodeStreamDisconnect(t *testing.T) {
+	state := useFakeFfmpeg(t)
+	// Only killing it stops it, the end of its input doesn't
+	t.Setenv("FFMPEG_HANG", "1")
+
+	// An endless stream, like a live video
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not really audio")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	server := newTranscodeServer(t, upstream.URL, findTranscodeFormat("MP3"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+
+	process := waitForProcess(t, filepath.Join(state, "pid"))
+
+	// The speaker moving on to the next track
+	cancel()
+	<-done
+
+	deadline := time.Now().Add(5 * time.Second)
+	for process.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			process.Kill()
+			t.Fatal("expected ffmpeg to be killed when the client went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForProcess waits for the fake ffmpeg to write its PID to path.
+func waitForProcess(t *testing.T, path string) *os.Process {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			process, err := os.FindProcess(pid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return process
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected ffmpeg to be started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
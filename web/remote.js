@@ -0,0 +1,174 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+// The token can be handed out as a link, e.g. http://host:9372/remote/#token=...
+if (location.hash.startsWith("#token=")) {
+	localStorage.setItem("token", location.hash.slice("#token=".length));
+	history.replaceState(null, "", location.pathname);
+}
+
+let token = localStorage.getItem("token") || "";
+let transport = {state: "", position: 0, duration: 0};
+let seeking = false;
+let events = null;
+
+function askToken() {
+	token = prompt("API token (see the YouSonos settings)") || "";
+	localStorage.setItem("token", token);
+	connect();
+}
+
+function showError(message) {
+	$("error").textContent = message;
+}
+
+async function api(method, path, body) {
+	const options = {method: method, headers: {"Authorization": "Bearer " + token}};
+	if (body !== undefined) {
+		options.headers["Content-Type"] = "application/json";
+		options.body = JSON.stringify(body);
+	}
+
+	const resp = await fetch("/api/v1" + path, options);
+	if (resp.status === 401 || resp.status === 403) {
+		askToken();
+		return null;
+	}
+	if (!resp.ok) {
+		const error = await resp.json().catch(() => ({error: resp.statusText}));
+		showError(error.error);
+		return null;
+	}
+
+	showError("");
+	return resp.status === 204 ? {} : resp.json();
+}
+
+function formatHMS(seconds) {
+	const pad = (n) => String(n).padStart(2, "0");
+	return pad(Math.floor(seconds / 3600)) + ":" + pad(Math.floor(seconds / 60) % 60) + ":" + pad(seconds % 60);
+}
+
+function playing() {
+	return transport.state === "PLAYING" || transport.state === "TRANSITIONING";
+}
+
+function renderDevices(devices) {
+	const select = $("device");
+	select.replaceChildren(...devices.map((device) => new Option(device.name, device.name, false, device.selected)));
+	if (!devices.some((device) => device.selected)) {
+		select.prepend(new Option("Select a device", "", true, true));
+	}
+}
+
+function renderTrack(track) {
+	if (track) {
+		$("title").textContent = track.title;
+		$("artwork").src = "https://i.ytimg.com/vi/" + encodeURIComponent(track.id) + "/hqdefault.jpg";
+	} else {
+		$("title").textContent = "Nothing is playing";
+		$("artwork").src = "emptythumbnail.png";
+	}
+}
+
+function renderTransport() {
+	$("play").innerHTML = playing() ? "&#x23F8;" : "&#x25B6;";
+	$("play").title = playing() ? "Pause" : "Play";
+
+	if (!seeking) {
+		$("position").max = transport.duration;
+		$("position").value = transport.position;
+		$("positionLabel").textContent = formatHMS(transport.position);
+	}
+}
+
+function renderVolume(volume) {
+	if (document.activeElement !== $("volume")) {
+		$("volume").value = volume;
+		$("volumeLabel").textContent = volume + "%";
+	}
+}
+
+function connect() {
+	if (events) {
+		events.close();
+	}
+
+	events = new EventSource("/api/v1/events?token=" + encodeURIComponent(token));
+	events.addEventListener("DeviceListChanged", (event) => renderDevices(JSON.parse(event.data)));
+	events.addEventListener("TrackChanged", (event) => renderTrack(JSON.parse(event.data).track));
+	events.addEventListener("TransportStateChanged", (event) => {
+		transport = JSON.parse(event.data);
+		renderTransport();
+	});
+	events.addEventListener("VolumeChanged", (event) => renderVolume(JSON.parse(event.data).volume));
+	events.onerror = () => {
+		// EventSource reconnects by itself, unless the token was rejected
+		fetch("/api/v1/devices", {headers: {"Authorization": "Bearer " + token}}).then((resp) => {
+			if (resp.status === 401 || resp.status === 403) {
+				events.close();
+				askToken();
+			}
+		});
+	};
+}
+
+$("device").addEventListener("change", (event) => {
+	api("PUT", "/device", {name: event.target.value});
+});
+
+$("play").addEventListener("click", () => {
+	api("POST", playing() ? "/pause" : "/play");
+});
+
+$("stop").addEventListener("click", () => api("POST", "/stop"));
+$("previous").addEventListener("click", () => api("POST", "/previous"));
+$("next").addEventListener("click", () => api("POST", "/next"));
+
+$("position").addEventListener("input", (event) => {
+	seeking = true;
+	$("positionLabel").textContent = formatHMS(Number(event.target.value));
+});
+
+$("position").addEventListener("change", async (event) => {
+	await api("POST", "/seek", {position: Number(event.target.value)});
+	seeking = false;
+});
+
+$("volume").addEventListener("input", (event) => {
+	$("volumeLabel").textContent = event.target.value + "%";
+});
+
+$("volume").addEventListener("change", (event) => {
+	api("PUT", "/volume", {volume: Number(event.target.value)});
+});
+
+$("urlForm").addEventListener("submit", async (event) => {
+	event.preventDefault();
+	if (await api("POST", "/play", {url: $("url").value})) {
+		$("url").value = "";
+	}
+});
+
+$("enqueue").addEventListener("click", async () => {
+	if (await api("POST", "/queue", {url: $("url").value})) {
+		$("url").value = "";
+	}
+});
+
+connect();
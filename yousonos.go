@@ -15,18 +15,16 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/xml"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
-	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -39,101 +37,111 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-type Root struct {
-	Device struct {
-		RoomName    string `xml:"roomName"`
-		DisplayName string `xml:"displayName"`
-	} `xml:"device"`
-}
-
 type Device struct {
 	Name string
 	Host string
+	UDN  string
 }
 
-var tick = false
-var globalSeconds = 0
-var songSeconds = 0
-var lastSeek time.Time
-var seekActive = false
-var sliderValue int
-var selectedDevice Device
-var sonosDevices = make(map[string]string)
-var channel = make(chan bool)
-var playing = false
+var subscribeMutex sync.Mutex
 
 func main() {
-	go redirector()
+	// Before creating the app, which needs a display
+	if len(os.Args) > 1 {
+		os.Exit(runCliWithStoredPreferences(os.Args[1:]))
+	}
 
-	a := app.NewWithID("nl.skbotnl.yousonos")
+	a := app.NewWithID(appId)
 	w := a.NewWindow("YouSonos")
 
+	loadSettings(a.Preferences())
+	loadStoredStreams(a.Preferences())
+	go invidiousPool.Run(5 * time.Minute)
+	go streamRegistry.Run(10 * time.Minute)
+
 	activeDevice := a.Preferences().String("ActiveDevice")
 	if activeDevice == "" {
 		dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 	}
 
-	devices, err := searchDevices()
+	err := discovery.Search(context.Background())
 	if err != nil {
 		dialog.ShowError(err, w)
 	}
 
-	for _, dev := range devices {
-		loc := dev.Get("Location")
-
-		resp, err := http.Get(loc)
-		if err != nil {
-			dialog.ShowError(err, w)
-			break
-		}
-		defer resp.Body.Close()
-
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			dialog.ShowError(err, w)
-			break
-		}
-
-		root := Root{}
-
-		err = xml.Unmarshal(bodyBytes, &root)
-		if err != nil {
-			dialog.ShowError(err, w)
-			break
-		}
-
-		u, err := url.Parse(loc)
-		if err != nil {
-			dialog.ShowError(err, w)
-			break
+	// A speaker that isn't found yet is selected once discovery sees it
+	if activeDevice != "" {
+		device, ok := discovery.Find(activeDevice)
+		if ok {
+			controller.SelectDevice(device)
 		}
+	}
 
-		host := u.Host
-
-		sonosDevices[fmt.Sprintf("%s (%s)", root.Device.RoomName, root.Device.DisplayName)] = host
+	// The redirector serves the API and the event callbacks, which use these
+	// from the moment it is started
+	controlApi.SelectDevice = func(device Device) error {
+		return switchDevice(a.Preferences(), device)
 	}
 
-	if activeDevice != "" {
-		host, ok := sonosDevices[activeDevice]
-		if !ok {
-			dialog.NewError(errors.New("could not find selected device"), w)
+	genaSubscriber.OnEvent = func(sub Subscription, values map[string]string) {
+		switch sub.Service {
+		case avTransportService:
+			// The position itself isn't evented, poll to pick up the rest
+			go controller.Poll(context.Background())
+		case renderingControlService:
+			mixer.HandleEvent(sub, values)
+			if sub.Host != controller.Device().Host {
+				return
+			}
+			volume, err := strconv.Atoi(values["Volume"])
+			if err != nil {
+				return
+			}
+			controller.Events().Publish(VolumeChanged{Volume: volume})
+		case groupRenderingControlService:
+			mixer.HandleEvent(sub, values)
+		case zoneGroupTopologyService:
+			state, ok := values["ZoneGroupState"]
+			if !ok {
+				return
+			}
+			err := topology.Update(state)
+			if err != nil {
+				log.Printf("Could not parse zone groups: %s", err)
+			}
 		}
+	}
 
-		selectedDevice = Device{
-			Name: activeDevice,
-			Host: "http://" + host,
-		}
+	err = redirector()
+	if err != nil {
+		dialog.ShowError(err, w)
 	}
 
 	makeTray(a, w)
 
+	activity := newActivityBar()
+
 	input := widget.NewEntry()
-	input.SetPlaceHolder("Enter Youtube URL...")
+	input.SetPlaceHolder("Enter a YouTube video or playlist URL, or search terms...")
 
 	positionLabel := widget.NewLabel("00:00:00")
 	slider := widget.NewSlider(0, 0)
 
+	// Seeking waits until the slider was left alone for half a second
+	var seekMutex sync.Mutex
+	var lastSeek time.Time
+	seekActive := false
+	sliderValue := 0
+	seeking := func() bool {
+		seekMutex.Lock()
+		defer seekMutex.Unlock()
+		return seekActive
+	}
+
 	slider.OnChanged = func(value float64) {
+		seekMutex.Lock()
+		defer seekMutex.Unlock()
+
 		lastSeek = time.Now()
 		sliderValue = int(value)
 
@@ -149,82 +157,59 @@ func main() {
 			go func() {
 				for {
 					time.Sleep(100 * time.Millisecond)
+					seekMutex.Lock()
 					diff := time.Since(lastSeek)
+					seekMutex.Unlock()
 					if diff.Milliseconds() >= 500 {
 						break
 					}
 				}
-				seek(sliderValue)
-
-				globalSeconds = sliderValue
 
-				seekActive = false
+				seekMutex.Lock()
+				target := sliderValue
+				seekMutex.Unlock()
+
+				activity.Run(w, "Seeking", func(ctx context.Context) error {
+					defer func() {
+						seekMutex.Lock()
+						seekActive = false
+						seekMutex.Unlock()
+					}()
+					return controller.Seek(ctx, target)
+				})
 			}()
 		}
 	}
 
-	currentVolume := 0
-	if (Device{}) != selectedDevice {
-		currentVolume, err = getVolume()
-		if err != nil {
-			dialog.ShowError(err, w)
-		}
-	}
-
-	volumeLabel := widget.NewLabel(fmt.Sprintf("%d%%", currentVolume))
+	volumeLabel := widget.NewLabel("0%")
 	volumeSlider := widget.NewSlider(0, 100)
-	volumeSlider.SetValue(float64(currentVolume))
+
+	volumeSender := newSliderSender(activity, w, "Changing the volume", controller.SetVolume)
 
 	volumeSlider.OnChanged = func(value float64) {
-		if (Device{}) == selectedDevice {
+		if !controller.HasDevice() {
 			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 			return
 		}
-
-		volume := int(value)
-		err := setVolume(volume)
-		if err != nil {
-			dialog.ShowError(err, w)
-			return
-		}
-
-		volumeLabel.Text = fmt.Sprintf("%d%%", volume)
-		volumeLabel.Refresh()
+		volumeSender.Send(int(value))
 	}
 
 	goButton := widget.NewButton("Go", nil)
+	searchButton := widget.NewButtonWithIcon("", theme.SearchIcon(), nil)
+	queueButton := widget.NewButtonWithIcon("", theme.ContentAddIcon(), nil)
 
 	playButton := widget.NewButtonWithIcon("", theme.MediaPlayIcon(), nil)
 	playButton.OnTapped = func() {
-		if (Device{}) == selectedDevice {
+		if !controller.HasDevice() {
 			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 			return
 		}
 
-		if playing {
-			tick = false
-			playing = false
-			err := pause()
-			if err != nil {
-				dialog.ShowError(err, w)
-				return
-			}
-			playButton.Icon = theme.MediaPlayIcon()
-			playButton.Refresh()
+		// The icon follows the state the controller publishes
+		if controller.Status().Playing() {
+			activity.Run(w, "Pausing", controller.Pause)
 		} else {
-			if slider.Value >= float64(songSeconds) {
-				slider.Value = 0
-				globalSeconds = 0
-			}
-			tick = true
-			playing = true
-			err := play()
-			if err != nil {
-				dialog.ShowError(err, w)
-				return
-			}
-			playButton.Icon = theme.MediaPauseIcon()
-			playButton.Refresh()
+			activity.Run(w, "Playing", controller.Play)
 		}
 	}
 	// pauseButton := widget.NewButton("Pause", func() {
@@ -235,9 +220,11 @@ func main() {
 	// })
 
 	stopButton := widget.NewButtonWithIcon("", theme.MediaStopIcon(), nil)
+	previousButton := widget.NewButtonWithIcon("", theme.MediaSkipPreviousIcon(), nil)
+	nextButton := widget.NewButtonWithIcon("", theme.MediaSkipNextIcon(), nil)
 
 	settingsButton := widget.NewButton("Settings", func() {
-		openSettings(a, *slider, *positionLabel)
+		openSettings(a)
 	})
 
 	playingLabel := widget.NewLabel("Nothing is playing")
@@ -249,153 +236,273 @@ func main() {
 	// sliderHBox := container.NewHBox(slider, positionLabel)
 	playingCenter := container.NewCenter(playingLabel)
 	videoBorder := container.NewBorder(nil, playingCenter, nil, nil, image)
-	buttonsBox := container.NewHBox(playButton, stopButton)
+	buttonsBox := container.NewHBox(previousButton, playButton, stopButton, nextButton)
 	buttonsCenter := container.NewCenter(buttonsBox)
 	// buttonsBorder := container.NewBorder(nil, nil, playButton, stopButton)
 	imageBorder := container.NewBorder(videoBorder, buttonsCenter, nil, nil)
 
-	inputBorder := container.NewBorder(nil, nil, nil, goButton, input)
+	inputBorder := container.NewBorder(nil, nil, nil, container.NewHBox(searchButton, queueButton, goButton), input)
 	sliderBorder := container.NewBorder(nil, nil, nil, positionLabel, slider)
-	volumeBorder := container.NewBorder(nil, nil, widget.NewIcon(theme.MediaMusicIcon()), volumeLabel, volumeSlider)
+	mixerButton := widget.NewButton("Mixer", func() {
+		openMixer(a)
+	})
+	volumeBorder := container.NewBorder(nil, nil, widget.NewIcon(theme.MediaMusicIcon()), container.NewHBox(volumeLabel, mixerButton), volumeSlider)
+
+	content := container.NewVBox(imageBorder, inputBorder, activity.Object(), settingsButton, sliderBorder, volumeBorder)
+
+	showTrack := func(track Track) {
+		go func() {
+			bodyBytes, err := loadThumbnail(context.Background(), fmt.Sprintf("https://i.ytimg.com/vi/%s/maxresdefault.jpg", track.YtId))
+			if err != nil {
+				return
+			}
+
+			image.Resource = fyne.NewStaticResource("maxresdefault.jpg", bodyBytes)
+			image.Refresh()
+		}()
+
+		playingLabel.Text = track.Title
+		playingLabel.Refresh()
+		playButton.Icon = theme.MediaPauseIcon()
+		playButton.Refresh()
 
-	content := container.NewVBox(imageBorder, inputBorder, settingsButton, sliderBorder, volumeBorder)
+		// Replaced by the speaker's own duration on the next poll
+		slider.Max = float64(track.LengthSeconds)
+		slider.Value = 0
+		slider.Refresh()
+	}
+
+	showNothing := func() {
+		slider.Max = 0
+		slider.Value = 0
+		slider.Refresh()
+
+		positionLabel.Text = "00:00:00"
+		positionLabel.Refresh()
+
+		playButton.Icon = theme.MediaPlayIcon()
+		playButton.Refresh()
+
+		playingLabel.Text = "Nothing is playing"
+		playingLabel.Refresh()
+
+		image.Resource = resourceEmptythumbnailPng
+		image.Refresh()
+	}
+
+	queuePanel := makeQueuePanel(w, activity)
+	searchPanel, search := makeSearchPanel(w, activity)
+	searchTab := container.NewTabItemWithIcon("Search", theme.SearchIcon(), searchPanel)
+	sideTabs := container.NewAppTabs(container.NewTabItemWithIcon("Queue", theme.ListIcon(), queuePanel), searchTab)
+
+	startSearch := func() {
+		if strings.TrimSpace(input.Text) == "" {
+			return
+		}
+		sideTabs.Select(searchTab)
+		search(input.Text)
+	}
+	searchButton.OnTapped = startSearch
 
 	goButton.OnTapped = func() {
-		if (Device{}) == selectedDevice {
+		// Searching works without a speaker
+		if isSearchQuery(input.Text) {
+			startSearch()
+			return
+		}
+
+		if !controller.HasDevice() {
 			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 			return
 		}
 
-		id := ""
-		title := ""
-		songSeconds, id, title, err = sonosHandler(input.Text)
-		if err != nil {
-			dialog.ShowError(err, w)
+		if playlistId, ok := getPlaylistId(input.Text); ok {
+			enqueuePlaylist(w, playlistId, true)
 			return
 		}
 
-		go func() {
-			readcloser, err := loadData(id)
-			if err != nil {
-				dialog.NewError(err, w)
-			}
+		ytUrl := input.Text
+		activity.Run(w, "Loading "+ytUrl, func(ctx context.Context) error {
+			_, err := controller.PlayUrl(ctx, ytUrl)
+			return err
+		})
+	}
 
-			defer readcloser.Close()
+	input.OnSubmitted = func(string) {
+		goButton.OnTapped()
+	}
 
-			image = canvas.NewImageFromReader(readcloser.(io.Reader), "maxresdefault.jpg")
-			image.SetMinSize(fyne.NewSize(200, 200))
-			image.FillMode = canvas.ImageFillContain
+	queueButton.OnTapped = func() {
+		if isSearchQuery(input.Text) {
+			startSearch()
+			return
+		}
 
-			videoBorder := container.NewBorder(nil, playingCenter, nil, nil, image)
-			imageBorder := container.NewBorder(videoBorder, buttonsCenter, nil, nil)
-			content = container.NewVBox(imageBorder, inputBorder, settingsButton, sliderBorder, volumeBorder)
+		if !controller.HasDevice() {
+			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
+			return
+		}
 
-			w.SetContent(content)
-		}()
+		if playlistId, ok := getPlaylistId(input.Text); ok {
+			enqueuePlaylist(w, playlistId, false)
+			input.SetText("")
+			return
+		}
 
-		err = play()
-		if err != nil {
-			dialog.ShowError(err, w)
+		ytUrl := input.Text
+		activity.Run(w, "Adding "+ytUrl, func(ctx context.Context) error {
+			_, err := controller.Enqueue(ctx, ytUrl)
+			if err != nil {
+				return err
+			}
+
+			// Unless something else was typed in the meantime
+			if input.Text == ytUrl {
+				input.SetText("")
+			}
+			return nil
+		})
+	}
+
+	skip := func(next bool) {
+		if !controller.HasDevice() {
+			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 			return
 		}
 
-		playingLabel.Text = title
-		playingLabel.Refresh()
-		playButton.Icon = theme.MediaPauseIcon()
-		playButton.Refresh()
+		if next {
+			activity.Run(w, "Skipping to the next track", controller.Queue().Next)
+		} else {
+			activity.Run(w, "Going back to the previous track", controller.Queue().Previous)
+		}
+	}
 
-		slider.Max = float64(songSeconds)
-		slider.Value = 0
-		globalSeconds = 0
-		tick = true
-		playing = true
+	previousButton.OnTapped = func() {
+		skip(false)
+	}
+
+	nextButton.OnTapped = func() {
+		skip(true)
 	}
 
 	stopButton.OnTapped = func() {
-		if (Device{}) == selectedDevice {
+		if !controller.HasDevice() {
 			dialog.ShowInformation("No device selected", "Go to the settings to select a device", w)
 			return
 		}
 
-		slider.Max = 0
-		slider.Value = 0
-		globalSeconds = 0
+		activity.Run(w, "Stopping", controller.Stop)
+	}
+
+	split := container.NewHSplit(content, sideTabs)
+	split.Offset = 0.6
+	w.SetContent(split)
+
+	showStatus := func(status PlaybackStatus) {
+		if status.Playing() {
+			playButton.Icon = theme.MediaPauseIcon()
+		} else {
+			playButton.Icon = theme.MediaPlayIcon()
+		}
+		playButton.Refresh()
+
+		// Don't fight the user while they are dragging the slider
+		if seeking() {
+			return
+		}
+
+		if status.Duration > 0 {
+			slider.Max = float64(status.Duration)
+		}
+		slider.Value = float64(status.Position)
 		slider.Refresh()
 
-		positionLabel.Text = "00:00:00"
+		positionLabel.Text = formatHMS(status.Position)
 		positionLabel.Refresh()
+	}
 
-		playing = false
-		playButton.Icon = theme.MediaPlayIcon()
-		playButton.Refresh()
+	controller.Events().Watch(func(event Event) {
+		switch event := event.(type) {
+		case TrackChanged:
+			if event.Track == nil {
+				showNothing()
+				return
+			}
+			showTrack(*event.Track)
+		case TransportStateChanged:
+			showStatus(event.Status)
+		case VolumeChanged:
+			// Not SetValue, that would send the volume straight back
+			volumeSlider.Value = float64(event.Volume)
+			volumeSlider.Refresh()
+			volumeLabel.Text = fmt.Sprintf("%d%%", event.Volume)
+			volumeLabel.Refresh()
+		}
+	})
 
-		playingLabel.Text = "Nothing is playing"
-		playingLabel.Refresh()
+	if controller.HasDevice() {
+		activity.Run(w, "Getting the volume", controller.RefreshVolume)
+	}
 
-		go func() {
-			image = canvas.NewImageFromResource(resourceEmptythumbnailPng)
-			image.SetMinSize(fyne.NewSize(200, 200))
-			image.FillMode = canvas.ImageFillContain
+	go controller.Run(1 * time.Second)
 
-			videoBorder := container.NewBorder(nil, playingCenter, nil, nil, image)
-			imageBorder := container.NewBorder(videoBorder, buttonsCenter, nil, nil)
-			content = container.NewVBox(imageBorder, inputBorder, settingsButton, sliderBorder, volumeBorder)
+	if controller.HasDevice() {
+		go subscribeEvents()
+	}
+	go genaSubscriber.Run(1 * time.Minute)
 
-			w.SetContent(content)
-		}()
+	coordinator := controller.TransportDevice()
+	topology.Watch(func() {
+		// Members that joined or left need their volume events
+		go resubscribeEvents()
 
-		tick = false
-		err := stop()
-		if err != nil {
-			dialog.ShowError(err, w)
+		current := controller.TransportDevice()
+		if current.UDN == coordinator.UDN {
 			return
 		}
-	}
+		coordinator = current
 
-	w.SetContent(content)
-
-	go func() {
-		for range time.Tick(1 * time.Second) {
-			if tick {
-				if int(slider.Value) >= songSeconds {
-					tick = false
-					continue
+		// The speaker joined or left a group, the queue now belongs to
+		// another speaker
+		controller.Queue().Reset()
+	})
+	go topology.Run(5*time.Minute, controller.Device)
+
+	// Follow the selected speaker to its new address, or pick up the saved
+	// one when it comes online after we started
+	discovery.Watch(func() {
+		selected := controller.Device()
+		device, ok := discovery.FindUDN(selected.UDN)
+		if (Device{}) == selected {
+			device, ok = discovery.Find(a.Preferences().String("ActiveDevice"))
+		}
+		if ok && device != selected {
+			controller.SelectDevice(device)
+			a.Preferences().SetString("ActiveDevice", device.Name)
+			if currentSettings().BindRedirector {
+				err := redirector()
+				if err != nil {
+					log.Printf("Could not restart the redirector: %s", err)
 				}
-				labelInt, _ := strconv.Atoi(positionLabel.Text)
-				positionLabel.Text = fmt.Sprint(labelInt + 1)
-
-				globalSeconds += 1
-				hour := int(globalSeconds / 3600)
-				minute := int(globalSeconds/60) % 60
-				second := globalSeconds % 60
-				hms := fmt.Sprintf("%02d:%02d:%02d\n", hour, minute, second)
-				positionLabel.Text = hms
-				positionLabel.Refresh()
-
-				slider.Value += 1
-				slider.Refresh()
 			}
+			go subscribeEvents()
+			go func() {
+				err := controller.RefreshVolume(context.Background())
+				if err != nil {
+					log.Printf("Could not get the volume: %s", err)
+				}
+			}()
 		}
-	}()
 
-	// Channel for setting everything to 0, because when called from openSettings it doesn't actually refresh
+		publishDevices()
+	})
+	publishDevices()
+
+	go discovery.Run(1 * time.Minute)
 	go func() {
-		for {
-			<-channel
-			slider.Max = 0
-			slider.Value = 0
-			globalSeconds = 0
-			slider.Refresh()
-			tick = false
-			positionLabel.Text = "00:00:00"
-			positionLabel.Refresh()
-
-			currentVolume, err := getVolume()
-			if err != nil {
-				dialog.ShowError(err, w)
-				continue
-			}
-			volumeSlider.SetValue(float64(currentVolume))
+		err := discovery.Listen()
+		if err != nil {
+			log.Printf("Could not listen for device announcements: %s", err)
 		}
 	}()
 
@@ -403,124 +510,432 @@ func main() {
 		w.Hide()
 	})
 
-	w.Resize(fyne.NewSize(600, 400))
+	w.Resize(fyne.NewSize(900, 400))
 	w.ShowAndRun()
 
+	genaSubscriber.Unsubscribe(context.Background())
+
 	// var wg sync.WaitGroup
 	// wg.Add(1)
 	// wg.Wait()
 }
 
-func openSettings(a fyne.App, slider widget.Slider, positionLabel widget.Label) {
-	w := a.NewWindow("Settings")
+// switchDevice makes device the selected speaker.
+func switchDevice(prefs fyne.Preferences, device Device) error {
+	controller.SelectDevice(device)
+	prefs.SetString("ActiveDevice", device.Name)
+
+	go subscribeEvents()
+	go func() {
+		err := controller.RefreshVolume(context.Background())
+		if err != nil {
+			log.Printf("Could not get the volume: %s", err)
+		}
+	}()
+	publishDevices()
+
+	if currentSettings().BindRedirector {
+		return redirector()
+	}
+	return nil
+}
+
+// loadSettings configures everything that has a setting from prefs.
+func loadSettings(prefs Preferences) {
+	invidiousPool.SetUrls(parseInstanceList(prefs.StringWithFallback("InvidiousInstances", strings.Join(defaultInvidiousInstances, "\n"))))
+	updateSettings(func(s *Settings) {
+		s.Resolver = newResolver(prefs)
+		s.Policy.MaxBitrate = prefs.Int("MaxBitrate") * 1000
+		s.ProxyStreams = prefs.Bool("ProxyStreams")
+		s.Transcode = findTranscodeFormat(prefs.String("Transcode"))
+		s.FfmpegPath = prefs.StringWithFallback("FfmpegPath", defaultFfmpegPath)
+		s.AdvertiseAddress = prefs.String("AdvertiseAddress")
+		s.BindRedirector = prefs.Bool("BindRedirector")
+	})
+
+	controlApi.SetToken(prefs.String("ApiToken"))
+	loadTimeouts(prefs)
+}
+
+// loadStoredStreams picks up the streams registered before the last restart
+// unless storing them is turned off.
+func loadStoredStreams(prefs Preferences) {
+	if !prefs.BoolWithFallback("PersistStreams", true) {
+		return
+	}
+
+	path, err := defaultStreamRegistryPath()
+	if err == nil {
+		err = streamRegistry.SetPath(path)
+	}
+	if err != nil {
+		log.Printf("Could not load stored streams: %s", err)
+	}
+}
 
-	names := make([]string, len(sonosDevices))
+// subscribeEvents subscribes to the events of the selected speaker. Without
+// them changes from other controllers still show up through polling, just
+// slower, so failing isn't worth a dialog.
+func subscribeEvents() {
+	// Needed first to know which speaker coordinates the group
+	err := topology.Refresh(context.Background(), controller.Device().Host)
+	if err != nil {
+		log.Printf("Could not get zone groups: %s", err)
+	}
 
-	i := 0
-	for a := range sonosDevices {
-		names[i] = a
-		i++
+	resubscribeEvents()
+}
+
+// resubscribeEvents brings the subscriptions in line with the current groups.
+func resubscribeEvents() {
+	subscribeMutex.Lock()
+	defer subscribeMutex.Unlock()
+
+	err := genaSubscriber.SubscribeDevice(context.Background(), controller.Device())
+	if err != nil {
+		log.Printf("Could not subscribe to speaker events: %s", err)
 	}
+}
 
-	selectWidget := widget.NewSelect(names, func(selected string) {
-		if selected == selectedDevice.Name {
+func deviceNames(devices []Device) []string {
+	names := make([]string, len(devices))
+	for i, device := range devices {
+		names[i] = device.Name
+	}
+	return names
+}
+
+func openSettings(a fyne.App) {
+	w := a.NewWindow("Settings")
+
+	// Requests still running when the window closes are cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	activity := newActivityBar()
+
+	selectWidget := widget.NewSelect(deviceNames(discovery.Devices()), func(selected string) {
+		if selected == controller.Device().Name {
 			return
 		}
-		selectedDevice = Device{
-			Name: selected,
-			Host: "http://" + sonosDevices[selected],
+		device, ok := discovery.Find(selected)
+		if !ok {
+			return
 		}
-		a.Preferences().SetString("ActiveDevice", selected)
 
-		channel <- true
+		err := switchDevice(a.Preferences(), device)
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
 	})
 
-	if (Device{}) != selectedDevice {
-		selectWidget.Selected = selectedDevice.Name
+	if controller.HasDevice() {
+		selectWidget.Selected = controller.Device().Name
 	}
 
-	vbox := container.NewVBox(selectWidget)
-	w.SetContent(vbox)
+	stopWatchingDevices := controller.Events().Watch(func(event Event) {
+		devices, ok := event.(DeviceListChanged)
+		if !ok {
+			return
+		}
+		selectWidget.Options = deviceNames(devices.Devices)
+		selectWidget.Selected = devices.Selected.Name
+		selectWidget.Refresh()
+	})
 
-	w.Resize(fyne.NewSize(600, 400))
-	w.Show()
-}
+	// What the widgets start out showing
+	current := currentSettings()
 
-func makeTray(a fyne.App, w fyne.Window) {
-	if desk, ok := a.(desktop.App); ok {
-		show := fyne.NewMenuItem("Show", func() {
-			w.Show()
-		})
+	resolverSelect := widget.NewSelect(resolverNames, func(selected string) {
+		a.Preferences().SetString("Resolver", selected)
+		useSelectedResolver(a.Preferences())
+	})
+	resolverSelect.Selected = current.Resolver.Name()
+
+	invidiousEntry := widget.NewMultiLineEntry()
+	invidiousEntry.SetPlaceHolder("One instance URL per line")
+	invidiousEntry.SetText(a.Preferences().StringWithFallback("InvidiousInstances", strings.Join(defaultInvidiousInstances, "\n")))
+	invidiousEntry.OnChanged = func(text string) {
+		a.Preferences().SetString("InvidiousInstances", text)
+		invidiousPool.SetUrls(parseInstanceList(text))
+	}
 
-		menu := fyne.NewMenu("YouSonos", show)
-		menu.Label = "YouSonos"
-		desk.SetSystemTrayMenu(menu)
+	activeInstanceLabel := widget.NewLabel("")
+	instanceList := widget.NewList(
+		func() int {
+			return len(invidiousPool.Instances())
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			instances := invidiousPool.Instances()
+			if id >= len(instances) {
+				return
+			}
+			item.(*widget.Label).SetText(instanceStatus(instances[id]))
+		},
+	)
+	refreshInstances := func() {
+		activeInstanceLabel.SetText("Active instance: " + invidiousPool.Active())
+		instanceList.Refresh()
 	}
-}
+	refreshInstances()
+	stopWatchingInstances := invidiousPool.Watch(refreshInstances)
+
+	groupPanel, refreshGroups := makeGroupPanel(w, activity)
+	stopWatchingGroups := topology.Watch(refreshGroups)
+
+	w.SetOnClosed(func() {
+		cancel()
+		activity.Cancel()
+		stopWatchingInstances()
+		stopWatchingDevices()
+		stopWatchingGroups()
+	})
 
-func searchDevices() ([]http.Header, error) {
-	query := "urn:schemas-upnp-org:device:ZonePlayer:1"
+	checkButton := widget.NewButton("Check now", func() {
+		go invidiousPool.Probe(ctx)
+	})
 
-	conn, err := net.ListenUDP("udp", nil)
-	if err != nil {
-		return nil, err
+	pipedEntry := widget.NewEntry()
+	pipedEntry.SetText(a.Preferences().StringWithFallback("PipedApiUrl", defaultPipedApiUrl))
+	pipedEntry.OnChanged = func(text string) {
+		a.Preferences().SetString("PipedApiUrl", strings.TrimSpace(text))
+		useSelectedResolver(a.Preferences())
 	}
-	defer conn.Close()
 
-	req := strings.Join([]string{
-		"M-SEARCH * HTTP/1.1",
-		"HOST: 239.255.255.250:1900",
-		"MAN: \"ssdp:discover\"",
-		"ST: " + query,
-		"MX: 1",
-	}, "\r\n")
+	ytDlpEntry := widget.NewEntry()
+	ytDlpEntry.SetText(a.Preferences().StringWithFallback("YtDlpPath", defaultYtDlpPath))
+	ytDlpEntry.OnChanged = func(text string) {
+		a.Preferences().SetString("YtDlpPath", strings.TrimSpace(text))
+		useSelectedResolver(a.Preferences())
+	}
 
-	addr, err := net.ResolveUDPAddr("udp", "239.255.255.250:1900")
-	if err != nil {
-		return nil, err
+	bitrates := []string{"No limit", "64 kbps", "128 kbps", "160 kbps", "256 kbps"}
+	bitrateSelect := widget.NewSelect(bitrates, func(selected string) {
+		kbps, _ := strconv.Atoi(strings.TrimSuffix(selected, " kbps"))
+		a.Preferences().SetInt("MaxBitrate", kbps)
+		updateSettings(func(s *Settings) {
+			s.Policy.MaxBitrate = kbps * 1000
+		})
+	})
+	bitrateSelect.Selected = bitrates[0]
+	if current.Policy.MaxBitrate > 0 {
+		bitrateSelect.Selected = fmt.Sprintf("%d kbps", current.Policy.MaxBitrate/1000)
 	}
 
-	_, err = conn.WriteTo([]byte(req), addr)
-	if err != nil {
-		return nil, err
+	streamModes := []string{"Redirect", "Proxy"}
+	streamModeSelect := widget.NewSelect(streamModes, func(selected string) {
+		proxy := selected == "Proxy"
+		updateSettings(func(s *Settings) {
+			s.ProxyStreams = proxy
+		})
+		a.Preferences().SetBool("ProxyStreams", proxy)
+	})
+	streamModeSelect.Selected = streamModes[0]
+	if current.ProxyStreams {
+		streamModeSelect.Selected = streamModes[1]
 	}
 
-	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	transcodeOptions := []string{"Off"}
+	for _, format := range transcodeFormats {
+		transcodeOptions = append(transcodeOptions, format.Name)
+	}
+	transcodeSelect := widget.NewSelect(transcodeOptions, func(selected string) {
+		format := findTranscodeFormat(selected)
+		updateSettings(func(s *Settings) {
+			s.Transcode = format
+		})
+		a.Preferences().SetString("Transcode", selected)
+	})
+	transcodeSelect.Selected = transcodeOptions[0]
+	if current.Transcode != nil {
+		transcodeSelect.Selected = current.Transcode.Name
+	}
 
-	var devices []http.Header
-	for {
-		buf := make([]byte, 65536)
+	ffmpegEntry := widget.NewEntry()
+	ffmpegEntry.SetText(current.FfmpegPath)
+	ffmpegEntry.OnChanged = func(text string) {
+		path := strings.TrimSpace(text)
+		updateSettings(func(s *Settings) {
+			s.FfmpegPath = path
+		})
+		a.Preferences().SetString("FfmpegPath", path)
+	}
 
-		n, _, err := conn.ReadFrom(buf)
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			break
-		} else if err != nil {
-			log.Printf("ReadFrom error: %s", err)
-			break
-		}
+	persistCheck := widget.NewCheck("Remember streams across restarts", func(checked bool) {
+		a.Preferences().SetBool("PersistStreams", checked)
 
-		r := bufio.NewReader(bytes.NewReader(buf[:n]))
+		path := ""
+		if checked {
+			var err error
+			path, err = defaultStreamRegistryPath()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
 
-		resp, err := http.ReadResponse(r, &http.Request{})
+		err := streamRegistry.SetPath(path)
 		if err != nil {
-			return nil, err
+			dialog.ShowError(err, w)
 		}
-		resp.Body.Close()
+	})
+	persistCheck.Checked = a.Preferences().BoolWithFallback("PersistStreams", true)
+
+	detected := "no device selected"
+	if controller.HasDevice() {
+		localIp, err := detectLocalAddress(controller.Device().Host)
+		if err == nil {
+			detected = localIp
+		}
+	}
 
-		for _, head := range resp.Header["St"] {
-			if head == query {
-				devices = append(devices, resp.Header)
-				break
+	advertiseEntry := widget.NewEntry()
+	advertiseEntry.SetPlaceHolder(fmt.Sprintf("Automatic (%s)", detected))
+	advertiseEntry.SetText(current.AdvertiseAddress)
+	advertiseEntry.OnSubmitted = func(text string) {
+		address := strings.TrimSpace(text)
+		updateSettings(func(s *Settings) {
+			s.AdvertiseAddress = address
+		})
+		a.Preferences().SetString("AdvertiseAddress", address)
+
+		if currentSettings().BindRedirector {
+			err := redirector()
+			if err != nil {
+				dialog.ShowError(err, w)
 			}
 		}
+		if controller.HasDevice() {
+			go subscribeEvents()
+		}
 	}
 
-	return devices, nil
+	bindCheck := widget.NewCheck("Only listen on this address", func(checked bool) {
+		updateSettings(func(s *Settings) {
+			s.BindRedirector = checked
+		})
+		a.Preferences().SetBool("BindRedirector", checked)
+
+		err := redirector()
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+	})
+	bindCheck.Checked = current.BindRedirector
+
+	apiTokenEntry := widget.NewEntry()
+	apiTokenEntry.SetPlaceHolder("Disabled")
+	apiTokenEntry.SetText(controlApi.Token())
+	apiTokenEntry.OnChanged = func(text string) {
+		token := strings.TrimSpace(text)
+		controlApi.SetToken(token)
+		a.Preferences().SetString("ApiToken", token)
+	}
+	generateButton := widget.NewButton("Generate", func() {
+		token, err := newToken()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		apiTokenEntry.SetText(token)
+	})
+
+	form := widget.NewForm(
+		widget.NewFormItem("Device", selectWidget),
+		widget.NewFormItem("Resolver", resolverSelect),
+		widget.NewFormItem("Maximum bitrate", bitrateSelect),
+		widget.NewFormItem("Stream mode", streamModeSelect),
+		widget.NewFormItem("Transcode unsupported audio to", transcodeSelect),
+		widget.NewFormItem("ffmpeg path", ffmpegEntry),
+		widget.NewFormItem("", persistCheck),
+		widget.NewFormItem("Advertised address", advertiseEntry),
+		widget.NewFormItem("", bindCheck),
+		widget.NewFormItem("API token", container.NewBorder(nil, nil, nil, generateButton, apiTokenEntry)),
+		widget.NewFormItem("Invidious instances", invidiousEntry),
+		widget.NewFormItem("Piped API", pipedEntry),
+		widget.NewFormItem("yt-dlp path", ytDlpEntry),
+	)
+
+	instanceHeader := container.NewBorder(nil, nil, nil, checkButton, activeInstanceLabel)
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Groups", groupPanel),
+		container.NewTabItem("Audio", makeAudioPanel(ctx, w, activity)),
+		container.NewTabItem("Invidious instances", container.NewBorder(instanceHeader, nil, nil, nil, instanceList)),
+		container.NewTabItem("Timeouts", makeTimeoutsPanel(a.Preferences())),
+	)
+	border := container.NewBorder(form, activity.Object(), nil, nil, tabs)
+	w.SetContent(border)
+
+	w.Resize(fyne.NewSize(600, 400))
+	w.Show()
+}
+
+func instanceStatus(instance Instance) string {
+	if instance.LastCheck.IsZero() {
+		return fmt.Sprintf("%s: not checked yet", instance.Url)
+	}
+	if !instance.Healthy() {
+		return fmt.Sprintf("%s: down (%s)", instance.Url, instance.LastError)
+	}
+	return fmt.Sprintf("%s: %d ms", instance.Url, instance.Latency.Milliseconds())
+}
+
+func makeTray(a fyne.App, w fyne.Window) {
+	if desk, ok := a.(desktop.App); ok {
+		show := fyne.NewMenuItem("Show", func() {
+			w.Show()
+		})
+
+		menu := fyne.NewMenu("YouSonos", show)
+		menu.Label = "YouSonos"
+		desk.SetSystemTrayMenu(menu)
+	}
+}
+
+// makeTimeoutsPanel lets every timeout be changed, in seconds. An empty
+// entry means the default.
+func makeTimeoutsPanel(prefs fyne.Preferences) fyne.CanvasObject {
+	form := widget.NewForm()
+	for _, setting := range timeoutSettings {
+		setting := setting
+
+		entry := widget.NewEntry()
+		entry.SetPlaceHolder(fmt.Sprintf("%d seconds", int(setting.Default.Seconds())))
+		if seconds := prefs.Int(setting.Key); seconds > 0 {
+			entry.SetText(strconv.Itoa(seconds))
+		}
+		entry.Validator = func(text string) error {
+			if strings.TrimSpace(text) == "" {
+				return nil
+			}
+			seconds, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil || seconds <= 0 {
+				return errors.New("not a number of seconds")
+			}
+			return nil
+		}
+		entry.OnChanged = func(text string) {
+			seconds, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil || seconds <= 0 {
+				seconds = 0
+			}
+			prefs.SetInt(setting.Key, seconds)
+			loadTimeouts(prefs)
+		}
+
+		form.Append(setting.Label, entry)
+	}
+	return container.NewVScroll(form)
 }
 
-func loadData(id string) (io.ReadCloser, error) {
-	ytimg := fmt.Sprintf("https://i.ytimg.com/vi/%s/maxresdefault.jpg", id)
-	req, err := http.NewRequest("GET", ytimg, nil)
+// loadThumbnail fetches the image at thumbnailUrl, giving up after
+// Timeouts.Thumbnail.
+func loadThumbnail(ctx context.Context, thumbnailUrl string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Thumbnail)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", thumbnailUrl, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -529,6 +944,11 @@ func loadData(id string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("thumbnail: %s", resp.Status)
+	}
 
-	return resp.Body, nil
+	return io.ReadAll(resp.Body)
 }
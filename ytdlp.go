@@ -0,0 +1,140 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type YtDlpFormat struct {
+	FormatId string  `json:"format_id"`
+	Url      string  `json:"url"`
+	Ext      string  `json:"ext"`
+	Protocol string  `json:"protocol"`
+	Acodec   string  `json:"acodec"`
+	Vcodec   string  `json:"vcodec"`
+	Abr      float64 `json:"abr"`
+	Tbr      float64 `json:"tbr"`
+	Height   int     `json:"height"`
+}
+
+type YtDlp struct {
+	Title     string        `json:"title"`
+	Duration  float64       `json:"duration"`
+	Thumbnail string        `json:"thumbnail"`
+	Formats   []YtDlpFormat `json:"formats"`
+}
+
+var ytDlpMimeTypes = map[string]string{
+	"m4a":  "audio/mp4",
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+	"mp3":  "audio/mpeg",
+}
+
+// YtDlpResolver resolves videos by running a local yt-dlp executable.
+type YtDlpResolver struct {
+	Path string
+}
+
+func (r *YtDlpResolver) Name() string {
+	return "yt-dlp"
+}
+
+func (r *YtDlpResolver) Resolve(ctx context.Context, id string) (Media, error) {
+	var stdout, stderr bytes.Buffer
+
+	ctx, cancel := withTimeout(ctx, currentTimeouts().Resolve)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.Path, "--dump-json", "--no-playlist", "--", "https://www.youtube.com/watch?v="+id)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		// The process was killed, what it printed is beside the point
+		if ctx.Err() != nil {
+			return Media{}, fmt.Errorf("yt-dlp: %w", ctx.Err())
+		}
+		message := strings.TrimSpace(stderr.String())
+		if message != "" {
+			return Media{}, fmt.Errorf("yt-dlp: %s", message)
+		}
+		return Media{}, fmt.Errorf("yt-dlp: %w", err)
+	}
+
+	res := YtDlp{}
+	err = json.Unmarshal(stdout.Bytes(), &res)
+	if err != nil {
+		return Media{}, err
+	}
+
+	if res.Title == "" && len(res.Formats) == 0 {
+		return Media{}, errors.New("yt-dlp returned no video information")
+	}
+
+	media := Media{
+		Id:            id,
+		Title:         res.Title,
+		LengthSeconds: int(res.Duration),
+		Thumbnail:     res.Thumbnail,
+	}
+
+	for _, format := range res.Formats {
+		// Manifests and storyboards can't be handed to the speaker
+		if format.Protocol != "https" && format.Protocol != "http" {
+			continue
+		}
+		if format.Acodec == "" || format.Acodec == "none" {
+			continue
+		}
+
+		audioOnly := format.Vcodec == "none"
+
+		mimeType := ytDlpMimeTypes[format.Ext]
+		if audioOnly {
+			mimeType = strings.Replace(mimeType, "video/", "audio/", 1)
+		}
+
+		bitrate := format.Abr
+		if bitrate == 0 {
+			bitrate = format.Tbr
+		}
+
+		resolution := ""
+		if !audioOnly && format.Height > 0 {
+			resolution = fmt.Sprintf("%dp", format.Height)
+		}
+
+		media.Streams = append(media.Streams, StreamCandidate{
+			Url:        format.Url,
+			MimeType:   mimeType,
+			Container:  format.Ext,
+			Codec:      format.Acodec,
+			Bitrate:    int(bitrate * 1000),
+			Resolution: resolution,
+			AudioOnly:  audioOnly,
+		})
+	}
+
+	return media, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 SKBotNL
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fakeYtDlp is a stand-in for yt-dlp. It prints the fixture in
+// testdata/ytdlp for the video ID at the end of its arguments, fails like
+// yt-dlp does for any other video, and hangs for the ID "hang".
+const fakeYtDlp = `#!/bin/sh
+for last; do :; done
+id=${last##*v=}
+if [ "$id" = hang ]; then
+	exec sleep 60
+fi
+if [ -f "$FIXTURES/$id.json" ]; then
+	exec cat "$FIXTURES/$id.json"
+fi
+echo "ERROR: [youtube] $id: Video unavailable" >&2
+exit 1
+`
+
+// useFakeYtDlp puts the fake yt-dlp first on PATH until the test ends.
+func useFakeYtDlp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yt-dlp is a shell script")
+	}
+
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "ytdlp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte(fakeYtDlp), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FIXTURES", fixtures)
+}
+
+func TestYtDlpResolver(t *testing.T) {
+	useFakeYtDlp(t)
+	resolver := &YtDlpResolver{Path: "yt-dlp"}
+
+	media, err := resolver.Resolve(context.Background(), "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Storyboards, manifests and video-only formats are left out
+	checkGolden(t, "ytdlp/dQw4w9WgXcQ", encodeGolden(t, media))
+}
+
+func TestYtDlpResolverErrors(t *testing.T) {
+	useFakeYtDlp(t)
+	resolver := &YtDlpResolver{Path: "yt-dlp"}
+
+	// What yt-dlp printed is more useful than its exit status
+	_, err := resolver.Resolve(context.Background(), "Wch3gJG2GJ4")
+	want := "yt-dlp: ERROR: [youtube] Wch3gJG2GJ4: Video unavailable"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+
+	missing := &YtDlpResolver{Path: filepath.Join(t.TempDir(), "yt-dlp")}
+	_, err = missing.Resolve(context.Background(), "dQw4w9WgXcQ")
+	if err == nil {
+		t.Error("expected a missing executable to fail")
+	}
+}
+
+func TestYtDlpResolverTimeout(t *testing.T) {
+	useFakeYtDlp(t)
+	useTimeouts(t, Timeouts{Resolve: 100 * time.Millisecond})
+	resolver := &YtDlpResolver{Path: "yt-dlp"}
+
+	err := expectWithin(t, 5*time.Second, func() error {
+		_, err := resolver.Resolve(context.Background(), "hang")
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected yt-dlp to be killed after the timeout, got %v", err)
+	}
+}